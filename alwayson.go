@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// autoplayBatch is how many songs autoplay or the radio queue at a time.
+const autoplayBatch = 10
+
+func (b *Bot) handleAlwaysOn(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if !canManageGuild(i) {
+		respondEphemeral(s, i, "You need the Manage Server permission to change 24/7 mode")
+		return
+	}
+
+	subcommand := i.ApplicationCommandData().Options[0]
+	switch subcommand.Name {
+	case "on":
+		b.handleAlwaysOnEnable(s, i, subcommand.Options)
+	case "off":
+		b.handleAlwaysOnDisable(s, i)
+	}
+}
+
+func (b *Bot) handleAlwaysOnEnable(s *discordgo.Session, i *discordgo.InteractionCreate, options []*discordgo.ApplicationCommandInteractionDataOption) {
+	var channelID, radio string
+	var autoplay bool
+	for _, option := range options {
+		switch option.Name {
+		case "channel":
+			channelID = option.ChannelValue(s).ID
+		case "autoplay":
+			autoplay = option.BoolValue()
+		case "radio":
+			radio = strings.TrimSpace(option.StringValue())
+		}
+	}
+
+	// Stay where the bot already is, or else where the member is.
+	if channelID == "" {
+		channelID = b.voiceChannel(i.GuildID)
+	}
+	if channelID == "" {
+		channelID = getUserVoiceChannel(s, i.GuildID, i.Member.User.ID)
+	}
+	if channelID == "" {
+		respondEphemeral(s, i, "Join a voice channel or pick one for the bot to stay in")
+		return
+	}
+
+	if radio != "" {
+		if err := b.guildConfig(i.GuildID).CheckSource(radio); err != nil {
+			respondEphemeral(s, i, fmt.Sprintf("Error: %v", err))
+			return
+		}
+	}
+
+	respondEphemeral(s, i, "Processing...")
+
+	notice, err := b.joinAlwaysOn(s, i.GuildID, channelID)
+	if errors.Is(err, errCannotSpeak) {
+		editResponse(s, i, fmt.Sprintf("Error: %v", err))
+		return
+	}
+	if err != nil {
+		log.Printf("Error joining 24/7 channel in guild %s: %v", i.GuildID, err)
+		editResponse(s, i, "Error joining voice channel")
+		return
+	}
+
+	if err := b.settings.Update(i.GuildID, func(gs *GuildSettings) {
+		gs.AlwaysOn = true
+		gs.AlwaysOnChannelID = channelID
+		gs.Autoplay = autoplay
+		gs.Radio = radio
+	}); err != nil {
+		log.Printf("Error saving guild settings: %v", err)
+		editResponse(s, i, "Error saving settings")
+		return
+	}
+
+	reply := fmt.Sprintf("24/7 mode is on, staying in <#%s>%s", channelID, describeFallback(autoplay, radio))
+	if notice != "" {
+		reply += "\n" + notice
+	}
+	editResponse(s, i, reply)
+}
+
+func (b *Bot) handleAlwaysOnDisable(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if err := b.settings.Update(i.GuildID, func(gs *GuildSettings) {
+		gs.AlwaysOn = false
+		gs.AlwaysOnChannelID = ""
+		gs.Autoplay = false
+		gs.Radio = ""
+	}); err != nil {
+		log.Printf("Error saving guild settings: %v", err)
+		respondEphemeral(s, i, "Error saving settings")
+		return
+	}
+	respondEphemeral(s, i, "24/7 mode is off")
+
+	b.mu.RLock()
+	state, ok := b.guilds[i.GuildID]
+	b.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	// Pick up the timers 24/7 mode was holding off.
+	b.checkListeners(s, i.GuildID, state)
+	state.mu.Lock()
+	idle := state.current == nil && state.voice != nil
+	state.mu.Unlock()
+	if idle {
+		timeout := b.settings.Get(i.GuildID).inactivityTimeout(b.guildConfig(i.GuildID))
+		state.startInactivityTimer(timeout, func() {
+			b.disconnectFromGuild(i.GuildID)
+		})
+	}
+}
+
+// describeFallback explains what plays when the queue runs out in 24/7 mode.
+func describeFallback(autoplay bool, radio string) string {
+	switch {
+	case autoplay && radio != "":
+		return fmt.Sprintf(" and playing songs like the last one, or `%s`, when the queue runs out", radio)
+	case autoplay:
+		return " and playing songs like the last one when the queue runs out"
+	case radio != "":
+		return fmt.Sprintf(" and playing `%s` when the queue runs out", radio)
+	}
+	return ""
+}
+
+// voiceChannel returns the channel the bot is connected to in a guild, or ""
+// when it is not connected.
+func (b *Bot) voiceChannel(guildID string) string {
+	b.mu.RLock()
+	state, ok := b.guilds[guildID]
+	b.mu.RUnlock()
+	if !ok {
+		return ""
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.voice == nil {
+		return ""
+	}
+	return state.voice.ChannelID
+}
+
+// joinAlwaysOn connects to the 24/7 channel of a guild, moving there if the
+// bot is in another channel, and stops any countdown to leaving. The notice
+// is the one from ensureVoiceConnection.
+func (b *Bot) joinAlwaysOn(s *discordgo.Session, guildID, channelID string) (string, error) {
+	state := b.getOrCreateGuildState(guildID)
+
+	state.mu.Lock()
+	connected := state.voice != nil
+	state.mu.Unlock()
+
+	var notice string
+	if !connected {
+		var err error
+		if notice, err = b.ensureVoiceConnection(s, guildID, channelID, state); err != nil {
+			b.disconnectFromGuild(guildID)
+			return "", err
+		}
+	} else if b.voiceChannel(guildID) != channelID {
+		// Joining again on an open connection moves it.
+		if _, err := s.ChannelVoiceJoin(guildID, channelID, false, true); err != nil {
+			return "", err
+		}
+		stage, stageNotice, err := joinStage(s, guildID, channelID)
+		state.mu.Lock()
+		state.stage = stage
+		state.mu.Unlock()
+		if err != nil {
+			return "", err
+		}
+		notice = stageNotice
+	}
+
+	state.mu.Lock()
+	state.cancelInactivityTimer()
+	state.mu.Unlock()
+	b.checkListeners(s, guildID, state)
+	return notice, nil
+}
+
+// restoreAlwaysOn rejoins the 24/7 channels that no saved session brought the
+// bot back to.
+func (b *Bot) restoreAlwaysOn(s *discordgo.Session) {
+	for guildID, channelID := range b.settings.AlwaysOnChannels() {
+		if b.voiceChannel(guildID) != "" {
+			continue
+		}
+
+		log.Printf("Rejoining 24/7 channel in guild %s", guildID)
+		notice, err := b.joinAlwaysOn(s, guildID, channelID)
+		if err != nil {
+			log.Printf("Error rejoining 24/7 channel in guild %s: %v", guildID, err)
+		} else if notice != "" {
+			log.Printf("Rejoined 24/7 stage in guild %s but must be invited to speak", guildID)
+		}
+	}
+}
+
+// queueAutoplay refills an empty queue in 24/7 mode after last finished: with
+// YouTube's mix for last if autoplay is on, otherwise or failing that from
+// the guild's radio. It returns the first song to play, or nil if there is
+// nothing to fall back to.
+func (b *Bot) queueAutoplay(guildID string, state *GuildState, last *Song) *Song {
+	settings := b.settings.Get(guildID)
+	if !settings.AlwaysOn {
+		return nil
+	}
+	config := b.guildConfig(guildID)
+
+	var songs []*Song
+	if settings.Autoplay {
+		if id := youTubeVideoID(last.URL); id != "" {
+			mix := fmt.Sprintf("https://www.youtube.com/watch?v=%s&list=RD%s", id, id)
+			resolved, err := b.resolveQuery(mix, last.ChannelID, config)
+			if err != nil {
+				log.Printf("Error getting autoplay songs: %v", err)
+			}
+			for _, song := range resolved {
+				// The mix starts with the song it is based on.
+				if youTubeVideoID(song.URL) != id {
+					songs = append(songs, song)
+				}
+			}
+		}
+	}
+	if len(songs) == 0 && settings.Radio != "" {
+		resolved, err := b.resolveQuery(settings.Radio, last.ChannelID, config)
+		if err != nil {
+			log.Printf("Error getting radio songs: %v", err)
+		}
+		songs = resolved
+	}
+	if len(songs) == 0 {
+		return nil
+	}
+
+	if len(songs) > autoplayBatch {
+		songs = songs[:autoplayBatch]
+	}
+	for _, song := range songs {
+		song.RequesterName = "Autoplay"
+		state.queue.Add(song)
+	}
+	log.Printf("Queued %d autoplay songs in guild %s", len(songs), guildID)
+	return state.queue.Get()
+}
+
+// youTubeVideoID extracts the video ID from a YouTube watch or short link, or
+// returns "" for anything else.
+func youTubeVideoID(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+
+	switch strings.TrimPrefix(u.Hostname(), "www.") {
+	case "youtube.com", "music.youtube.com", "m.youtube.com":
+		return u.Query().Get("v")
+	case "youtu.be":
+		return strings.TrimPrefix(u.Path, "/")
+	}
+	return ""
+}
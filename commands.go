@@ -1,56 +1,40 @@
 package main
 
-import "github.com/bwmarrin/discordgo"
+import (
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// queuePagePrefix prefixes the custom ID of the /queue navigation
+	// buttons; the target page number follows it.
+	queuePagePrefix = "queue_page:"
+	queuePageSize   = 10
+
+	// seekStep is how far the rewind and fast-forward buttons jump.
+	seekStep = 10 * time.Second
+
+	maxVolume = 200
+
+	maxCrossfadeSeconds = 12
+
+	minInactivityTimeout = 5
+	maxInactivityTimeout = 24 * 60 * 60
+
+	maxVoteSkipPercent = 100
+)
 
 var (
-	musicButtons = []discordgo.MessageComponent{
-		discordgo.ActionsRow{
-			Components: []discordgo.MessageComponent{
-				discordgo.Button{
-					Emoji: &discordgo.ComponentEmoji{
-						Name: "⏸️",
-					},
-					Style:    discordgo.SecondaryButton,
-					CustomID: "music_pause",
-				},
-				discordgo.Button{
-					Emoji: &discordgo.ComponentEmoji{
-						Name: "⏭️",
-					},
-					Style:    discordgo.SecondaryButton,
-					CustomID: "music_skip",
-				},
-				discordgo.Button{
-					Emoji: &discordgo.ComponentEmoji{
-						Name: "⏹️",
-					},
-					Style:    discordgo.SecondaryButton,
-					CustomID: "music_stop",
-				},
-			},
-		},
-	}
+	minQueuePosition = 1.0
+	minVolume        = 0.0
+	minCrossfade     = 0.0
+	minEQGain        = -eqMaxGain
+	minTimeout       = float64(minInactivityTimeout)
+	minQueueLength   = 0.0
+	minVoteSkip      = 0.0
 
-	musicButtonsNoSkip = []discordgo.MessageComponent{
-		discordgo.ActionsRow{
-			Components: []discordgo.MessageComponent{
-				discordgo.Button{
-					Emoji: &discordgo.ComponentEmoji{
-						Name: "⏸️",
-					},
-					Style:    discordgo.SecondaryButton,
-					CustomID: "music_pause",
-				},
-				discordgo.Button{
-					Emoji: &discordgo.ComponentEmoji{
-						Name: "⏹️",
-					},
-					Style:    discordgo.SecondaryButton,
-					CustomID: "music_stop",
-				},
-			},
-		},
-	}
+	manageGuildPermission int64 = discordgo.PermissionManageServer
 
 	commands = []*discordgo.ApplicationCommand{
 		{
@@ -77,6 +61,355 @@ var (
 			Name:        "pause",
 			Description: "Pause or resume the current song",
 		},
+		{
+			Name:        "queue",
+			Description: "Show the songs waiting in the queue",
+		},
+		{
+			Name:        "remove",
+			Description: "Remove songs from the queue",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "song",
+					Description: "Remove the song at a position in the queue",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							Name:        "position",
+							Description: "Position of the song in the queue",
+							Required:    true,
+							MinValue:    &minQueuePosition,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "mine",
+					Description: "Remove every song you queued",
+				},
+			},
+		},
+		{
+			Name:        "move",
+			Description: "Move a song to a different position in the queue",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        "from",
+					Description: "Current position of the song",
+					Required:    true,
+					MinValue:    &minQueuePosition,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        "to",
+					Description: "New position of the song",
+					Required:    true,
+					MinValue:    &minQueuePosition,
+				},
+			},
+		},
+		{
+			Name:        "swap",
+			Description: "Swap two songs in the queue",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        "first",
+					Description: "Position of the first song",
+					Required:    true,
+					MinValue:    &minQueuePosition,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        "second",
+					Description: "Position of the second song",
+					Required:    true,
+					MinValue:    &minQueuePosition,
+				},
+			},
+		},
+		{
+			Name:        "clear",
+			Description: "Remove every song from the queue",
+		},
+		{
+			Name:        "skipto",
+			Description: "Skip to a position in the queue",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        "position",
+					Description: "Position of the song to play next",
+					Required:    true,
+					MinValue:    &minQueuePosition,
+				},
+			},
+		},
+		{
+			Name:        "shuffle",
+			Description: "Shuffle the songs in the queue",
+		},
+		{
+			Name:        "seek",
+			Description: "Jump to a position in the current song",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "position",
+					Description: "Position to jump to, e.g. 1:30",
+					Required:    true,
+				},
+			},
+		},
+		{
+			Name:        "volume",
+			Description: "Show or change the playback volume",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        "percent",
+					Description: "Volume in percent (0-200)",
+					MinValue:    &minVolume,
+					MaxValue:    maxVolume,
+				},
+			},
+		},
+		{
+			Name:        "crossfade",
+			Description: "Show or change the crossfade between songs",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        "seconds",
+					Description: "Crossfade length in seconds (0 turns it off)",
+					MinValue:    &minCrossfade,
+					MaxValue:    maxCrossfadeSeconds,
+				},
+			},
+		},
+		{
+			Name:        "filter",
+			Description: "Apply an audio effect preset",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "preset",
+					Description: "Preset to apply, or clear to remove it",
+					Choices:     filterChoices(),
+				},
+			},
+		},
+		{
+			Name:        "eq",
+			Description: "Adjust the 10-band equalizer",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "set",
+					Description: "Set the gain of one band",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							Name:        "band",
+							Description: "Band to change",
+							Required:    true,
+							Choices:     eqBandChoices(),
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionNumber,
+							Name:        "gain",
+							Description: "Gain in dB (-12 to 12)",
+							Required:    true,
+							MinValue:    &minEQGain,
+							MaxValue:    eqMaxGain,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "show",
+					Description: "Show the current equalizer settings",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "reset",
+					Description: "Reset every band to 0 dB",
+				},
+			},
+		},
+		{
+			Name:        "loop",
+			Description: "Set or cycle the loop mode",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "mode",
+					Description: "Loop mode to use; cycles to the next mode when omitted",
+					Choices: []*discordgo.ApplicationCommandOptionChoice{
+						{Name: "off", Value: "off"},
+						{Name: "track", Value: "track"},
+						{Name: "queue", Value: "queue"},
+					},
+				},
+			},
+		},
+		{
+			Name:                     "settings",
+			Description:              "View or change this server's settings",
+			DefaultMemberPermissions: &manageGuildPermission,
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "view",
+					Description: "Show the current settings",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "set",
+					Description: "Change one or more settings",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							Name:        "volume",
+							Description: "Volume in percent (0-200)",
+							MinValue:    &minVolume,
+							MaxValue:    maxVolume,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "loop",
+							Description: "Loop mode when the bot joins",
+							Choices: []*discordgo.ApplicationCommandOptionChoice{
+								{Name: "off", Value: "off"},
+								{Name: "track", Value: "track"},
+								{Name: "queue", Value: "queue"},
+							},
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							Name:        "inactivity_timeout",
+							Description: "Seconds before leaving an idle voice channel",
+							MinValue:    &minTimeout,
+							MaxValue:    maxInactivityTimeout,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							Name:        "max_queue_length",
+							Description: "Maximum number of queued songs (0 for unlimited)",
+							MinValue:    &minQueueLength,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							Name:        "vote_skip_percent",
+							Description: "Percent of listeners who must vote to skip (0 lets anyone skip)",
+							MinValue:    &minVoteSkip,
+							MaxValue:    maxVoteSkipPercent,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionBoolean,
+							Name:        "fair_queue",
+							Description: "Play songs round-robin between the people who requested them",
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionRole,
+							Name:        "dj_role",
+							Description: "Role allowed to control playback",
+						},
+						{
+							Type:         discordgo.ApplicationCommandOptionChannel,
+							Name:         "announce_channel",
+							Description:  "Channel for now-playing messages",
+							ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText},
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "preset",
+							Description: "Audio preset applied when the bot joins",
+							Choices:     filterChoices(),
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "reset",
+					Description: "Reset a setting to the default",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "setting",
+							Description: "Setting to reset",
+							Required:    true,
+							Choices: []*discordgo.ApplicationCommandOptionChoice{
+								{Name: "volume", Value: "volume"},
+								{Name: "loop", Value: "loop"},
+								{Name: "inactivity_timeout", Value: "inactivity_timeout"},
+								{Name: "max_queue_length", Value: "max_queue_length"},
+								{Name: "vote_skip_percent", Value: "vote_skip_percent"},
+								{Name: "fair_queue", Value: "fair_queue"},
+								{Name: "dj_role", Value: "dj_role"},
+								{Name: "announce_channel", Value: "announce_channel"},
+								{Name: "preset", Value: "preset"},
+								{Name: "crossfade", Value: "crossfade"},
+								{Name: "eq", Value: "eq"},
+								{Name: "all", Value: "all"},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:        "stats",
+			Description: "Show how many songs someone has requested",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionUser,
+					Name:        "user",
+					Description: "Member to show; defaults to you",
+				},
+			},
+		},
+		{
+			Name:                     "247",
+			Description:              "Keep the bot in a voice channel around the clock",
+			DefaultMemberPermissions: &manageGuildPermission,
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "on",
+					Description: "Stay in a voice channel and rejoin it after restarts",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:         discordgo.ApplicationCommandOptionChannel,
+							Name:         "channel",
+							Description:  "Channel to stay in; defaults to the current one",
+							ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildVoice, discordgo.ChannelTypeGuildStageVoice},
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionBoolean,
+							Name:        "autoplay",
+							Description: "Play songs like the last one when the queue runs out",
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "radio",
+							Description: "URL, playlist or search to play when the queue runs out",
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "off",
+					Description: "Leave again when idle or alone",
+				},
+			},
+		},
+		{
+			Name:        "reload-config",
+			Description: "Reload the bot configuration (bot owner only)",
+		},
 		{
 			Name:        "dj",
 			Description: "Let the AI DJ play a set for you",
@@ -91,3 +424,129 @@ var (
 		},
 	}
 )
+
+// filterChoices lists the /filter presets followed by "clear".
+func filterChoices() []*discordgo.ApplicationCommandOptionChoice {
+	choices := make([]*discordgo.ApplicationCommandOptionChoice, 0, len(audioPresets)+1)
+	for _, preset := range audioPresets {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  preset.Description,
+			Value: preset.Name,
+		})
+	}
+	return append(choices, &discordgo.ApplicationCommandOptionChoice{
+		Name:  "Clear",
+		Value: "clear",
+	})
+}
+
+// eqBandChoices lists the equalizer bands by frequency; the value is the band
+// index.
+func eqBandChoices() []*discordgo.ApplicationCommandOptionChoice {
+	choices := make([]*discordgo.ApplicationCommandOptionChoice, 0, len(eqBands))
+	for i, hz := range eqBands {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  formatFrequency(hz),
+			Value: i,
+		})
+	}
+	return choices
+}
+
+// playerStatus describes the playback state reflected by the now-playing
+// message and its buttons.
+type playerStatus struct {
+	Paused     bool
+	CanSkip    bool // There is a song to skip to
+	CanShuffle bool // At least two songs are queued
+	Loop       LoopMode
+	Volume     int
+	Preset     string // Active /filter preset, empty when none
+
+	SkipVotes       int // Votes to skip the current song
+	SkipVotesNeeded int // Votes needed when the last vote was cast
+}
+
+// musicButtons builds the controls attached to the now-playing message.
+func musicButtons(c playerStatus) []discordgo.MessageComponent {
+	pauseEmoji := "⏸️"
+	if c.Paused {
+		pauseEmoji = "▶️"
+	}
+
+	buttons := []discordgo.MessageComponent{
+		discordgo.Button{
+			Emoji: &discordgo.ComponentEmoji{
+				Name: pauseEmoji,
+			},
+			Style:    discordgo.SecondaryButton,
+			CustomID: "music_pause",
+		},
+	}
+
+	if c.CanSkip {
+		buttons = append(buttons, discordgo.Button{
+			Emoji: &discordgo.ComponentEmoji{
+				Name: "⏭️",
+			},
+			Style:    discordgo.SecondaryButton,
+			CustomID: "music_skip",
+		})
+	}
+
+	loopStyle := discordgo.SecondaryButton
+	if c.Loop != LoopOff {
+		loopStyle = discordgo.PrimaryButton
+	}
+
+	buttons = append(buttons,
+		discordgo.Button{
+			Emoji: &discordgo.ComponentEmoji{
+				Name: "⏹️",
+			},
+			Style:    discordgo.SecondaryButton,
+			CustomID: "music_stop",
+		},
+		discordgo.Button{
+			Emoji: &discordgo.ComponentEmoji{
+				Name: c.Loop.Emoji(),
+			},
+			Style:    loopStyle,
+			CustomID: "music_loop",
+		},
+	)
+
+	if c.CanShuffle {
+		buttons = append(buttons, discordgo.Button{
+			Emoji: &discordgo.ComponentEmoji{
+				Name: "🔀",
+			},
+			Style:    discordgo.SecondaryButton,
+			CustomID: "music_shuffle",
+		})
+	}
+
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: buttons,
+		},
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Emoji: &discordgo.ComponentEmoji{
+						Name: "⏪",
+					},
+					Style:    discordgo.SecondaryButton,
+					CustomID: "music_rewind",
+				},
+				discordgo.Button{
+					Emoji: &discordgo.ComponentEmoji{
+						Name: "⏩",
+					},
+					Style:    discordgo.SecondaryButton,
+					CustomID: "music_forward",
+				},
+			},
+		},
+	}
+}
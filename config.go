@@ -1,16 +1,24 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"math"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
 )
 
+// defaultConfigFile is loaded when it exists and neither --config nor
+// CONFIG_FILE names another file.
+const defaultConfigFile = "config.yaml"
+
 func pow(x, y float64) float64 {
 	return math.Pow(x, y)
 }
@@ -23,10 +31,16 @@ type Config struct {
 	CookiesPath         string
 	SpotifyClientID     string
 	SpotifyClientSecret string
+	SpotifyMarket       string // Country code for artist top tracks and shows
 	YtDlpProxy          string
 	GeminiAPIKey        string
 	DJPromptFilePath    string
 
+	// Persistence
+	SettingsFilePath string // JSON file holding per-guild settings
+	SessionFilePath  string // JSON file holding playback state across restarts, empty disables
+	StatsFilePath    string // JSON file holding per-member request stats, empty keeps them in memory
+
 	// Opus Encoder Settings
 	OpusBitrate        int  // SetBitrate(bits int)
 	OpusComplexity     int  // SetComplexity(complexity int)
@@ -38,6 +52,7 @@ type Config struct {
 	BufferSize         int     // Frame size in samples per channel
 	AudioVolume        float64 // Volume multiplier (0.0-2.0)
 	AudioNormalization bool    // Enable loudness normalization
+	CrossfadeSeconds   int     // Default crossfade between songs (0-12, 0 = off)
 
 	// Advanced Audio Processing
 	AudioCompressor     bool    // Enable dynamic range compression
@@ -60,137 +75,564 @@ type Config struct {
 
 	// Quality Preset
 	QualityPreset string // "performance", "balanced", "quality"
-}
 
-func LoadConfig() *Config {
-	if err := godotenv.Load(); err != nil {
-		log.Println("Info: .env file not found, falling back to environment variables.")
-	}
+	// Playback Defaults (guilds can override these with /settings)
+	InactivityTimeout   int // Seconds before leaving an idle voice channel
+	EmptyChannelTimeout int // Seconds before leaving a voice channel everyone has left
+	MaxQueueLength      int // Maximum songs in a queue, 0 for unlimited
+	VoteSkipPercent     int // Share of listeners needed to skip, 0 lets anyone skip
 
-	// Load quality preset first to set defaults
-	preset := getEnvAsString("QUALITY_PRESET", "balanced")
+	// Now-Playing Message
+	NowPlayingInterval int // Seconds between progress updates when nothing changes
 
-	config := &Config{
-		// Discord Bot Configuration
-		BotToken: os.Getenv("BOT_TOKEN"),
+	// Sources
+	SourceYouTube    bool // YouTube links and plain search queries
+	SourceSpotify    bool // Spotify links
+	SourceSoundCloud bool // SoundCloud links
+	SourceOther      bool // Links to any other site yt-dlp supports
+
+	// FilePath is the config file the values were read from, empty if none.
+	FilePath string
+
+	// guilds holds the effective config of each guild with overrides in the
+	// config file.
+	guilds map[string]*Config
+}
+
+// configField ties a Config field to its key. Keys are the environment
+// variable names; the config file uses them in lower case, and nested
+// sections are joined with an underscore, so "opus: {bitrate: 96000}" sets
+// OPUS_BITRATE.
+type configField struct {
+	key   string
+	value any  // *string, *int, *bool or *float64
+	guild bool // whether the guilds section of the config file may override it
+}
 
+// fields lists every setting that can be configured.
+func (c *Config) fields() []configField {
+	return []configField{
+		{"BOT_TOKEN", &c.BotToken, false},
+
+		{"COOKIES_PATH", &c.CookiesPath, false},
+		{"SPOTIFY_CLIENT_ID", &c.SpotifyClientID, false},
+		{"SPOTIFY_CLIENT_SECRET", &c.SpotifyClientSecret, false},
+		{"SPOTIFY_MARKET", &c.SpotifyMarket, false},
+		{"YT_DLP_PROXY", &c.YtDlpProxy, false},
+		{"GEMINI_API_KEY", &c.GeminiAPIKey, false},
+		{"DJ_PROMPT_FILE_PATH", &c.DJPromptFilePath, false},
+
+		{"SETTINGS_FILE_PATH", &c.SettingsFilePath, false},
+		{"SESSION_FILE_PATH", &c.SessionFilePath, false},
+		{"STATS_FILE_PATH", &c.StatsFilePath, false},
+
+		{"OPUS_BITRATE", &c.OpusBitrate, true},
+		{"OPUS_COMPLEXITY", &c.OpusComplexity, true},
+		{"OPUS_INBAND_FEC", &c.OpusInBandFEC, true},
+		{"OPUS_PACKET_LOSS_PERC", &c.OpusPacketLossPerc, true},
+		{"OPUS_DTX", &c.OpusDTX, true},
+
+		{"BUFFER_SIZE", &c.BufferSize, true},
+		{"AUDIO_VOLUME", &c.AudioVolume, true},
+		{"AUDIO_NORMALIZATION", &c.AudioNormalization, true},
+		{"CROSSFADE_SECONDS", &c.CrossfadeSeconds, true},
+
+		{"AUDIO_COMPRESSOR", &c.AudioCompressor, true},
+		{"COMPRESSOR_THRESHOLD", &c.CompressorThreshold, true},
+		{"COMPRESSOR_RATIO", &c.CompressorRatio, true},
+		{"COMPRESSOR_ATTACK", &c.CompressorAttack, true},
+		{"COMPRESSOR_RELEASE", &c.CompressorRelease, true},
+
+		{"ENABLE_RESAMPLING", &c.EnableResampling, true},
+		{"RESAMPLING_QUALITY", &c.ResamplingQuality, true},
+
+		{"FFMPEG_THREAD_QUEUE_SIZE", &c.FFmpegThreadQueueSize, true},
+		{"FFMPEG_BUFFER_SIZE", &c.FFmpegBufferSize, true},
+		{"FFMPEG_RT_BUFFER_SIZE", &c.FFmpegRTBufferSize, true},
+		{"FFMPEG_PROBE_SIZE", &c.FFmpegProbeSize, true},
+		{"FFMPEG_ANALYZE_DURATION", &c.FFmpegAnalyzeDuration, true},
+		{"FFMPEG_RECONNECT_DELAY", &c.FFmpegReconnectDelay, true},
+
+		{"QUALITY_PRESET", &c.QualityPreset, false},
+
+		{"INACTIVITY_TIMEOUT", &c.InactivityTimeout, true},
+		{"EMPTY_CHANNEL_TIMEOUT", &c.EmptyChannelTimeout, true},
+		{"MAX_QUEUE_LENGTH", &c.MaxQueueLength, true},
+		{"VOTE_SKIP_PERCENT", &c.VoteSkipPercent, true},
+
+		{"NOW_PLAYING_INTERVAL", &c.NowPlayingInterval, false},
+
+		{"SOURCES_YOUTUBE", &c.SourceYouTube, true},
+		{"SOURCES_SPOTIFY", &c.SourceSpotify, true},
+		{"SOURCES_SOUNDCLOUD", &c.SourceSoundCloud, true},
+		{"SOURCES_OTHER", &c.SourceOther, true},
+	}
+}
+
+// defaultConfig returns the built-in defaults, before the config file, the
+// environment and the quality preset are applied.
+func defaultConfig() *Config {
+	return &Config{
 		// External Service Configuration
-		CookiesPath:         os.Getenv("COOKIES_PATH"),
-		SpotifyClientID:     os.Getenv("SPOTIFY_CLIENT_ID"),
-		SpotifyClientSecret: os.Getenv("SPOTIFY_CLIENT_SECRET"),
-		YtDlpProxy:          os.Getenv("YT_DLP_PROXY"),
-		GeminiAPIKey:        os.Getenv("GEMINI_API_KEY"),
-		DJPromptFilePath:    getEnvAsString("DJ_PROMPT_FILE_PATH", "djprompt.txt"),
+		SpotifyMarket:    "US",
+		DJPromptFilePath: "djprompt.txt",
+
+		// Persistence
+		SettingsFilePath: "guild_settings.json",
+		SessionFilePath:  "sessions.json",
+		StatsFilePath:    "stats.json",
 
 		// Opus Encoder Settings - Optimized for music streaming on Discord
-		OpusBitrate:        getEnvAsInt("OPUS_BITRATE", 128000),     // 128kbps - Discord's max
-		OpusComplexity:     getEnvAsInt("OPUS_COMPLEXITY", 10),      // 10 for best quality.
-		OpusInBandFEC:      getEnvAsBool("OPUS_INBAND_FEC", true),   // Forward Error Correction
-		OpusPacketLossPerc: getEnvAsInt("OPUS_PACKET_LOSS_PERC", 5), // Expected packet loss %
-		OpusDTX:            getEnvAsBool("OPUS_DTX", false),         // DTX off for music
+		OpusBitrate:        128000, // 128kbps - Discord's max
+		OpusComplexity:     10,     // 10 for best quality.
+		OpusInBandFEC:      true,   // Forward Error Correction
+		OpusPacketLossPerc: 5,      // Expected packet loss %
+		OpusDTX:            false,  // DTX off for music
 
 		// Audio Processing Settings
-		BufferSize:         getEnvAsInt("BUFFER_SIZE", 960),           // 960 samples = 20ms @ 48kHz
-		AudioVolume:        getEnvAsFloat("AUDIO_VOLUME", 1.0),        // Default: no change
-		AudioNormalization: getEnvAsBool("AUDIO_NORMALIZATION", true), // EBU R128 normalization
+		BufferSize:         960,  // 960 samples = 20ms @ 48kHz
+		AudioVolume:        1.0,  // Default: no change
+		AudioNormalization: true, // EBU R128 normalization
+		CrossfadeSeconds:   0,    // Crossfade off by default
 
 		// Advanced Audio Processing
-		AudioCompressor:     getEnvAsBool("AUDIO_COMPRESSOR", true),       // Light compression by default
-		CompressorThreshold: getEnvAsFloat("COMPRESSOR_THRESHOLD", -20.0), // -20dB threshold
-		CompressorRatio:     getEnvAsFloat("COMPRESSOR_RATIO", 4.0),       // 4:1 ratio
-		CompressorAttack:    getEnvAsInt("COMPRESSOR_ATTACK", 5),          // 5ms attack
-		CompressorRelease:   getEnvAsInt("COMPRESSOR_RELEASE", 50),        // 50ms release
+		AudioCompressor:     true,  // Light compression by default
+		CompressorThreshold: -20.0, // -20dB threshold
+		CompressorRatio:     4.0,   // 4:1 ratio
+		CompressorAttack:    5,     // 5ms attack
+		CompressorRelease:   50,    // 50ms release
 
 		// Resampling Settings
-		EnableResampling:  getEnvAsBool("ENABLE_RESAMPLING", true), // High-quality resampling
-		ResamplingQuality: getEnvAsInt("RESAMPLING_QUALITY", 28),   // SoX HQ (16-33)
+		EnableResampling:  true, // High-quality resampling
+		ResamplingQuality: 28,   // SoX HQ (16-33)
 
 		// FFmpeg Performance Settings
-		FFmpegThreadQueueSize: getEnvAsInt("FFMPEG_THREAD_QUEUE_SIZE", 512),
-		FFmpegBufferSize:      getEnvAsString("FFMPEG_BUFFER_SIZE", "512k"),
-		FFmpegRTBufferSize:    getEnvAsString("FFMPEG_RT_BUFFER_SIZE", "256M"),
-		FFmpegProbeSize:       getEnvAsInt("FFMPEG_PROBE_SIZE", 32),
-		FFmpegAnalyzeDuration: getEnvAsInt("FFMPEG_ANALYZE_DURATION", 0),
-		FFmpegReconnectDelay:  getEnvAsInt("FFMPEG_RECONNECT_DELAY", 5),
+		FFmpegThreadQueueSize: 512,
+		FFmpegBufferSize:      "512k",
+		FFmpegRTBufferSize:    "256M",
+		FFmpegProbeSize:       32,
+		FFmpegAnalyzeDuration: 0,
+		FFmpegReconnectDelay:  5,
 
 		// Quality Preset
-		QualityPreset: preset,
+		QualityPreset: "balanced",
+
+		// Playback Defaults
+		InactivityTimeout:   30,
+		EmptyChannelTimeout: 120,
+		MaxQueueLength:      0,
+		VoteSkipPercent:     50,
+
+		// Now-Playing Message
+		NowPlayingInterval: 15,
+
+		// Sources
+		SourceYouTube:    true,
+		SourceSpotify:    true,
+		SourceSoundCloud: true,
+		SourceOther:      true,
+	}
+}
+
+// ConfigError describes one invalid configuration value.
+type ConfigError struct {
+	Key     string // Environment variable name of the setting
+	Guild   string // Guild whose override is invalid, empty for global values
+	Problem string
+}
+
+func (e ConfigError) Error() string {
+	if e.Guild != "" {
+		return fmt.Sprintf("%s (guild %s): %s", e.Key, e.Guild, e.Problem)
+	}
+	return fmt.Sprintf("%s: %s", e.Key, e.Problem)
+}
+
+// ConfigErrors lists every invalid value found while loading the
+// configuration.
+type ConfigErrors []ConfigError
+
+func (e ConfigErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, problem := range e {
+		lines[i] = problem.Error()
+	}
+	if len(lines) == 1 {
+		return lines[0]
+	}
+	return fmt.Sprintf("%d invalid values:\n  %s", len(lines), strings.Join(lines, "\n  "))
+}
+
+// err returns e as an error, or nil if there are no problems.
+func (e ConfigErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// envFileKeys records which variables were last set from the .env file, so a
+// reload can update or remove them without touching the real environment.
+var envFileKeys = map[string]bool{}
+
+// applyEnvFile loads .env into the environment. Like godotenv.Load, variables
+// already set in the real environment take precedence, but values that came
+// from a previous load of the file are replaced.
+func applyEnvFile() {
+	values, err := godotenv.Read()
+	if err != nil {
+		log.Println("Info: .env file not found, falling back to environment variables.")
+	}
+
+	for key := range envFileKeys {
+		if _, ok := values[key]; !ok {
+			os.Unsetenv(key)
+		}
+	}
+
+	loaded := make(map[string]bool, len(values))
+	for key, value := range values {
+		if _, set := os.LookupEnv(key); set && !envFileKeys[key] {
+			continue
+		}
+		os.Setenv(key, value)
+		loaded[key] = true
+	}
+	envFileKeys = loaded
+}
+
+// LoadConfig reads the configuration from the config file, .env and the
+// environment, in increasing order of precedence. path names the config file;
+// if it is empty CONFIG_FILE is used, and failing that config.yaml is read if
+// it exists. It is called once at startup and again on reload; the returned
+// Config is never modified afterwards.
+//
+// Every invalid value is reported in the returned ConfigErrors rather than
+// stopping at the first one.
+func LoadConfig(path string) (*Config, error) {
+	applyEnvFile()
+
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	required := path != ""
+	if path == "" {
+		path = defaultConfigFile
 	}
 
+	values, guilds, err := readConfigFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !required {
+		path, err = "", nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	config := defaultConfig()
+	config.FilePath = path
+
+	loader := &configLoader{env: true, path: path, file: values, used: make(map[string]bool)}
+	for _, field := range config.fields() {
+		loader.load(field)
+	}
+	loader.checkUnknown()
+
 	// Apply preset defaults
-	config.applyPreset()
+	config.applyPreset(loader.isSet)
 
-	// Validate configuration
-	config.Validate()
+	problems := append(loader.problems, config.validate()...)
 
-	return config
+	if len(guilds) > 0 {
+		config.guilds = make(map[string]*Config, len(guilds))
+	}
+	for _, guildID := range sortedKeys(guilds) {
+		guild, guildProblems := config.loadGuild(guildID, path, guilds[guildID])
+		config.guilds[guildID] = guild
+		problems = append(problems, guildProblems...)
+	}
+
+	if err := problems.err(); err != nil {
+		return nil, err
+	}
+	return config, nil
 }
 
-func getEnvAsInt(key string, fallback int) int {
-	if value, ok := os.LookupEnv(key); ok {
-		if i, err := strconv.Atoi(value); err == nil {
-			return i
+// loadGuild applies one guild's overrides from the config file on top of c.
+func (c *Config) loadGuild(guildID, path string, values map[string]*yaml.Node) (*Config, ConfigErrors) {
+	guild := *c
+	guild.guilds = nil
+
+	loader := &configLoader{path: path, file: values, guild: guildID, used: make(map[string]bool)}
+	if _, err := strconv.ParseUint(guildID, 10, 64); err != nil {
+		loader.problems = append(loader.problems, ConfigError{
+			Key:     "GUILDS",
+			Problem: fmt.Sprintf("%q is not a guild ID", guildID),
+		})
+	}
+
+	overridden := make(map[string]bool)
+	for _, field := range guild.fields() {
+		if !loader.isSet(field.key) {
+			continue
 		}
-		log.Printf("Warning: Invalid integer value for %s: %s, using default: %d", key, value, fallback)
+		if !field.guild {
+			loader.used[field.key] = true
+			loader.errorf(field.key, "cannot be overridden per guild")
+			continue
+		}
+		loader.load(field)
+		overridden[field.key] = true
 	}
-	return fallback
+	loader.checkUnknown()
+
+	// Only report range problems for the values this guild overrides; the
+	// rest were already checked globally.
+	for _, problem := range guild.validate() {
+		if overridden[problem.Key] {
+			problem.Guild = guildID
+			loader.problems = append(loader.problems, problem)
+		}
+	}
+
+	return &guild, loader.problems
 }
 
-func getEnvAsBool(key string, fallback bool) bool {
-	if value, ok := os.LookupEnv(key); ok {
-		if b, err := strconv.ParseBool(value); err == nil {
-			return b
+// startupChanges lists the keys of the settings that differ from old but are
+// only read at startup, so a reload cannot apply them.
+func (c *Config) startupChanges(old *Config) []string {
+	var changed []string
+	for _, setting := range []struct {
+		key      string
+		new, old string
+	}{
+		{"BOT_TOKEN", c.BotToken, old.BotToken},
+		{"SETTINGS_FILE_PATH", c.SettingsFilePath, old.SettingsFilePath},
+		{"SESSION_FILE_PATH", c.SessionFilePath, old.SessionFilePath},
+		{"STATS_FILE_PATH", c.StatsFilePath, old.StatsFilePath},
+	} {
+		if setting.new != setting.old {
+			changed = append(changed, setting.key)
 		}
-		log.Printf("Warning: Invalid boolean value for %s: %s, using default: %v", key, value, fallback)
 	}
-	return fallback
+	return changed
+}
+
+// ForGuild returns the configuration for a guild, with any overrides from
+// the guilds section of the config file applied.
+func (c *Config) ForGuild(guildID string) *Config {
+	if guild, ok := c.guilds[guildID]; ok {
+		return guild
+	}
+	return c
 }
 
-func getEnvAsFloat(key string, fallback float64) float64 {
-	if value, ok := os.LookupEnv(key); ok {
-		if f, err := strconv.ParseFloat(value, 64); err == nil {
-			return f
+// readConfigFile parses the YAML config file at path. Nested sections are
+// flattened into upper-case keys, and the guilds section is returned
+// separately, keyed by guild ID.
+func readConfigFile(path string) (map[string]*yaml.Node, map[string]map[string]*yaml.Node, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	values := make(map[string]*yaml.Node)
+	guilds := make(map[string]map[string]*yaml.Node)
+	if len(doc.Content) == 0 {
+		// Empty file
+		return values, guilds, nil
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, nil, fmt.Errorf("%s: line %d: expected a mapping of settings", path, root.Line)
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if strings.EqualFold(key.Value, "guilds") {
+			if value.Tag == "!!null" {
+				continue
+			}
+			if value.Kind != yaml.MappingNode {
+				return nil, nil, fmt.Errorf("%s: line %d: guilds must map guild IDs to settings", path, value.Line)
+			}
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				guildValues := make(map[string]*yaml.Node)
+				if err := flattenConfig(guildValues, "", value.Content[j+1]); err != nil {
+					return nil, nil, fmt.Errorf("%s: %w", path, err)
+				}
+				guilds[value.Content[j].Value] = guildValues
+			}
+			continue
+		}
+		if err := flattenConfig(values, strings.ToUpper(key.Value), value); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
 		}
-		log.Printf("Warning: Invalid float value for %s: %s, using default: %.2f", key, value, fallback)
 	}
-	return fallback
+
+	return values, guilds, nil
 }
 
-func getEnvAsString(key string, fallback string) string {
-	if value, ok := os.LookupEnv(key); ok {
-		return value
+// flattenConfig adds node to values under key, descending into mappings.
+func flattenConfig(values map[string]*yaml.Node, key string, node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		if _, ok := values[key]; ok {
+			return fmt.Errorf("line %d: %s is set more than once", node.Line, strings.ToLower(key))
+		}
+		values[key] = node
+		return nil
 	}
-	return fallback
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		name := strings.ToUpper(node.Content[i].Value)
+		if key != "" {
+			name = key + "_" + name
+		}
+		if err := flattenConfig(values, name, node.Content[i+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configLoader reads settings from the environment and the config file,
+// collecting a ConfigError for every value it cannot use.
+type configLoader struct {
+	env   bool                  // Whether the environment is consulted
+	path  string                // Config file name, for error messages
+	file  map[string]*yaml.Node // Flattened config file values
+	guild string                // Guild the file values apply to, if any
+
+	used     map[string]bool
+	problems ConfigErrors
+}
+
+// lookup returns the raw value for key and a description of where it came
+// from. The environment takes precedence over the config file.
+func (l *configLoader) lookup(key string) (value, source string, ok bool) {
+	if l.env {
+		if value, ok := os.LookupEnv(key); ok {
+			return value, "environment", true
+		}
+	}
+	node, ok := l.file[key]
+	if !ok {
+		return "", "", false
+	}
+	source = fmt.Sprintf("%s line %d", l.path, node.Line)
+	if node.Kind != yaml.ScalarNode {
+		l.errorf(key, "expected a single value (%s)", source)
+		return "", "", false
+	}
+	return node.Value, source, true
+}
+
+// isSet reports whether key is given explicitly rather than by default.
+func (l *configLoader) isSet(key string) bool {
+	if l.env {
+		if _, ok := os.LookupEnv(key); ok {
+			return true
+		}
+	}
+	_, ok := l.file[key]
+	return ok
+}
+
+// load sets field from the environment or config file, if either has it.
+func (l *configLoader) load(field configField) {
+	l.used[field.key] = true
+
+	value, source, ok := l.lookup(field.key)
+	if !ok {
+		return
+	}
+
+	switch dst := field.value.(type) {
+	case *string:
+		*dst = value
+	case *int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			l.errorf(field.key, "%q is not a valid integer (%s)", value, source)
+			return
+		}
+		*dst = i
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			l.errorf(field.key, "%q is not a valid boolean (%s)", value, source)
+			return
+		}
+		*dst = b
+	case *float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			l.errorf(field.key, "%q is not a valid number (%s)", value, source)
+			return
+		}
+		*dst = f
+	}
+}
+
+// checkUnknown reports config file keys that do not match any setting.
+func (l *configLoader) checkUnknown() {
+	for _, key := range sortedKeys(l.file) {
+		if !l.used[key] {
+			l.errorf(key, "unknown setting (%s line %d)", l.path, l.file[key].Line)
+		}
+	}
+}
+
+func (l *configLoader) errorf(key, format string, args ...any) {
+	l.problems = append(l.problems, ConfigError{
+		Key:     key,
+		Guild:   l.guild,
+		Problem: fmt.Sprintf(format, args...),
+	})
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 // applyPreset applies configuration based on quality preset
-func (c *Config) applyPreset() {
-	// Only apply if not overridden by environment variables
+func (c *Config) applyPreset(isSet func(key string) bool) {
+	// Only apply if not overridden by the environment or config file
 	switch c.QualityPreset {
 	case "performance":
 		// Optimize for low CPU usage
-		if os.Getenv("OPUS_COMPLEXITY") == "" {
+		if !isSet("OPUS_COMPLEXITY") {
 			c.OpusComplexity = 6
 		}
-		if os.Getenv("ENABLE_RESAMPLING") == "" {
+		if !isSet("ENABLE_RESAMPLING") {
 			c.EnableResampling = false
 		}
-		if os.Getenv("AUDIO_COMPRESSOR") == "" {
+		if !isSet("AUDIO_COMPRESSOR") {
 			c.AudioCompressor = false
 		}
-		if os.Getenv("FFMPEG_BUFFER_SIZE") == "" {
+		if !isSet("FFMPEG_BUFFER_SIZE") {
 			c.FFmpegBufferSize = "256k"
 		}
 
 	case "quality":
 		// Maximum quality settings
-		if os.Getenv("RESAMPLING_QUALITY") == "" {
+		if !isSet("RESAMPLING_QUALITY") {
 			c.ResamplingQuality = 33
 		}
-		if os.Getenv("FFMPEG_BUFFER_SIZE") == "" {
+		if !isSet("FFMPEG_BUFFER_SIZE") {
 			c.FFmpegBufferSize = "1M"
 		}
-		if os.Getenv("FFMPEG_RT_BUFFER_SIZE") == "" {
+		if !isSet("FFMPEG_RT_BUFFER_SIZE") {
 			c.FFmpegRTBufferSize = "512M"
 		}
 
@@ -199,24 +641,38 @@ func (c *Config) applyPreset() {
 	}
 }
 
-// Validate ensures configuration values are within acceptable ranges
-func (c *Config) Validate() error {
+// validate ensures configuration values are within acceptable ranges. It
+// returns a ConfigError for every value that is not.
+func (c *Config) validate() ConfigErrors {
+	var problems ConfigErrors
+	invalid := func(key, format string, args ...any) {
+		problems = append(problems, ConfigError{Key: key, Problem: fmt.Sprintf(format, args...)})
+	}
+
+	if c.BotToken == "" {
+		invalid("BOT_TOKEN", "is required")
+	}
+
+	// Validate quality preset
+	switch c.QualityPreset {
+	case "performance", "balanced", "quality":
+	default:
+		invalid("QUALITY_PRESET", "%q is not one of performance, balanced or quality", c.QualityPreset)
+	}
+
 	// Validate Opus complexity (0-10)
 	if c.OpusComplexity < 0 || c.OpusComplexity > 10 {
-		log.Printf("Warning: OpusComplexity %d is outside valid range (0-10), using 9", c.OpusComplexity)
-		c.OpusComplexity = 9
+		invalid("OPUS_COMPLEXITY", "%d is outside valid range (0-10)", c.OpusComplexity)
 	}
 
 	// Validate bitrate
 	if c.OpusBitrate < 12000 || c.OpusBitrate > 128000 {
-		log.Printf("Warning: OpusBitrate %d is outside Discord range (12000-128000), using 128000", c.OpusBitrate)
-		c.OpusBitrate = 128000
+		invalid("OPUS_BITRATE", "%d is outside Discord range (12000-128000)", c.OpusBitrate)
 	}
 
 	// Validate packet loss percentage (0-100)
 	if c.OpusPacketLossPerc < 0 || c.OpusPacketLossPerc > 100 {
-		log.Printf("Warning: OpusPacketLossPerc %d is outside valid range (0-100), using 5", c.OpusPacketLossPerc)
-		c.OpusPacketLossPerc = 5
+		invalid("OPUS_PACKET_LOSS_PERC", "%d is outside valid range (0-100)", c.OpusPacketLossPerc)
 	}
 
 	// Validate buffer size for 48kHz
@@ -230,49 +686,133 @@ func (c *Config) Validate() error {
 	}
 
 	if _, ok := validBufferSizes[c.BufferSize]; !ok {
-		log.Printf("Warning: BufferSize %d is not a standard Opus frame size, using 960 (20ms)", c.BufferSize)
-		c.BufferSize = 960
+		invalid("BUFFER_SIZE", "%d is not a standard Opus frame size (120, 240, 480, 960, 1920 or 2880)", c.BufferSize)
 	}
 
 	// Validate audio volume (0.0-2.0 recommended)
 	if c.AudioVolume < 0.0 || c.AudioVolume > 10.0 {
-		log.Printf("Warning: AudioVolume %.2f is outside safe range (0.0-10.0), using 1.0", c.AudioVolume)
-		c.AudioVolume = 1.0
+		invalid("AUDIO_VOLUME", "%.2f is outside safe range (0.0-10.0)", c.AudioVolume)
+	}
+
+	// Validate crossfade duration
+	if c.CrossfadeSeconds < 0 || c.CrossfadeSeconds > maxCrossfadeSeconds {
+		invalid("CROSSFADE_SECONDS", "%d is outside valid range (0-%d)", c.CrossfadeSeconds, maxCrossfadeSeconds)
 	}
 
 	// Validate compressor settings
 	if c.CompressorThreshold > 0 {
-		log.Printf("Warning: CompressorThreshold %.2f should be negative (in dB), using -20.0", c.CompressorThreshold)
-		c.CompressorThreshold = -20.0
+		invalid("COMPRESSOR_THRESHOLD", "%.2f should be negative (in dB)", c.CompressorThreshold)
 	}
 
 	if c.CompressorRatio < 1.0 || c.CompressorRatio > 20.0 {
-		log.Printf("Warning: CompressorRatio %.2f is outside typical range (1.0-20.0), using 4.0", c.CompressorRatio)
-		c.CompressorRatio = 4.0
+		invalid("COMPRESSOR_RATIO", "%.2f is outside typical range (1.0-20.0)", c.CompressorRatio)
+	}
+
+	if c.CompressorAttack < 0 {
+		invalid("COMPRESSOR_ATTACK", "%d must not be negative", c.CompressorAttack)
+	}
+
+	if c.CompressorRelease < 0 {
+		invalid("COMPRESSOR_RELEASE", "%d must not be negative", c.CompressorRelease)
 	}
 
 	// Validate resampling quality (16-33 for SoX)
 	if c.ResamplingQuality < 16 || c.ResamplingQuality > 33 {
-		log.Printf("Warning: ResamplingQuality %d is outside SoX range (16-33), using 28", c.ResamplingQuality)
-		c.ResamplingQuality = 28
+		invalid("RESAMPLING_QUALITY", "%d is outside SoX range (16-33)", c.ResamplingQuality)
 	}
 
 	// Validate FFmpeg settings
 	if c.FFmpegThreadQueueSize < 128 || c.FFmpegThreadQueueSize > 2048 {
-		log.Printf("Warning: FFmpegThreadQueueSize %d is outside recommended range (128-2048), using 512", c.FFmpegThreadQueueSize)
-		c.FFmpegThreadQueueSize = 512
+		invalid("FFMPEG_THREAD_QUEUE_SIZE", "%d is outside recommended range (128-2048)", c.FFmpegThreadQueueSize)
 	}
 
 	if c.FFmpegReconnectDelay < 1 || c.FFmpegReconnectDelay > 60 {
-		log.Printf("Warning: FFmpegReconnectDelay %d is outside reasonable range (1-60), using 5", c.FFmpegReconnectDelay)
-		c.FFmpegReconnectDelay = 5
+		invalid("FFMPEG_RECONNECT_DELAY", "%d is outside reasonable range (1-60)", c.FFmpegReconnectDelay)
+	}
+
+	// Validate playback defaults
+	if c.InactivityTimeout < minInactivityTimeout || c.InactivityTimeout > maxInactivityTimeout {
+		invalid("INACTIVITY_TIMEOUT", "%d is outside valid range (%d-%d)", c.InactivityTimeout, minInactivityTimeout, maxInactivityTimeout)
+	}
+
+	if c.EmptyChannelTimeout < 0 || c.EmptyChannelTimeout > maxInactivityTimeout {
+		invalid("EMPTY_CHANNEL_TIMEOUT", "%d is outside valid range (0-%d)", c.EmptyChannelTimeout, maxInactivityTimeout)
+	}
+
+	if c.MaxQueueLength < 0 {
+		invalid("MAX_QUEUE_LENGTH", "%d must not be negative, use 0 for unlimited", c.MaxQueueLength)
+	}
+
+	if c.VoteSkipPercent < 0 || c.VoteSkipPercent > 100 {
+		invalid("VOTE_SKIP_PERCENT", "%d is outside valid range (0-100)", c.VoteSkipPercent)
 	}
 
+	if c.NowPlayingInterval < 5 || c.NowPlayingInterval > 300 {
+		invalid("NOW_PLAYING_INTERVAL", "%d is outside valid range (5-300)", c.NowPlayingInterval)
+	}
+
+	if len(c.SpotifyMarket) != 2 || strings.Trim(strings.ToUpper(c.SpotifyMarket), "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		invalid("SPOTIFY_MARKET", "%q is not a two-letter country code", c.SpotifyMarket)
+	}
+
+	return problems
+}
+
+// CheckSource returns an error if query points at a source that is turned
+// off in the sources section of the configuration.
+func (c *Config) CheckSource(query string) error {
+	switch {
+	case isSpotifyLink(query):
+		if !c.SourceSpotify {
+			return fmt.Errorf("Spotify links are disabled")
+		}
+	case strings.Contains(query, "soundcloud.com"):
+		if !c.SourceSoundCloud {
+			return fmt.Errorf("SoundCloud links are disabled")
+		}
+	case !strings.HasPrefix(query, "http"),
+		strings.Contains(query, "youtube.com"),
+		strings.Contains(query, "youtu.be"):
+		if !c.SourceYouTube {
+			return fmt.Errorf("YouTube is disabled")
+		}
+	default:
+		if !c.SourceOther {
+			return fmt.Errorf("only links to enabled sources can be played")
+		}
+	}
 	return nil
 }
 
-// BuildAudioFilter constructs the FFmpeg audio filter chain based on config
-func (c *Config) BuildAudioFilter() string {
+// runConfigCheck loads the configuration, prints every problem with it and
+// returns the exit code for --check-config.
+func runConfigCheck(path string) int {
+	config, err := LoadConfig(path)
+	if err != nil {
+		var problems ConfigErrors
+		if errors.As(err, &problems) {
+			for _, problem := range problems {
+				fmt.Fprintln(os.Stderr, problem)
+			}
+			fmt.Fprintf(os.Stderr, "%d invalid config values\n", len(problems))
+		} else {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return 1
+	}
+
+	source := "environment only"
+	if config.FilePath != "" {
+		source = config.FilePath
+	}
+	fmt.Printf("Config OK (%s, %d guild overrides)\n", source, len(config.guilds))
+	return 0
+}
+
+// BuildAudioFilter constructs the FFmpeg audio filter chain based on config.
+// Effects are inserted after resampling so that compression and normalization
+// still apply to the result.
+func (c *Config) BuildAudioFilter(effects ...string) string {
 	filters := []string{}
 
 	// Resampling (first in chain for efficiency)
@@ -283,6 +823,9 @@ func (c *Config) BuildAudioFilter() string {
 		))
 	}
 
+	// Per-guild effects
+	filters = append(filters, effects...)
+
 	// Dynamic range compression
 	if c.AudioCompressor {
 		// Convert dB to linear for threshold
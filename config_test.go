@@ -0,0 +1,251 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loadTestConfig runs LoadConfig in an empty directory holding the given
+// config file and .env, with only env set among the config variables.
+func loadTestConfig(t *testing.T, file, dotenv string, env map[string]string) (*Config, error) {
+	t.Helper()
+
+	dir := t.TempDir()
+	t.Chdir(dir)
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if dotenv != "" {
+		if err := os.WriteFile(".env", []byte(dotenv), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Setenv restores the variables afterwards, including those the .env
+	// file sets.
+	for _, field := range defaultConfig().fields() {
+		t.Setenv(field.key, "")
+		os.Unsetenv(field.key)
+	}
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+	t.Cleanup(func() { envFileKeys = map[string]bool{} })
+
+	return LoadConfig(path)
+}
+
+func TestReadConfigFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   string
+		values map[string]string
+		guilds map[string]map[string]string
+		err    string
+	}{
+		{
+			name:   "empty",
+			file:   "",
+			values: map[string]string{},
+			guilds: map[string]map[string]string{},
+		},
+		{
+			name:   "top level keys",
+			file:   "bot_token: abc\nvote_skip_percent: 30\n",
+			values: map[string]string{"BOT_TOKEN": "abc", "VOTE_SKIP_PERCENT": "30"},
+			guilds: map[string]map[string]string{},
+		},
+		{
+			name:   "nested sections",
+			file:   "opus:\n  bitrate: 96000\n  inband_fec: false\nffmpeg:\n  rt:\n    buffer_size: 64M\n",
+			values: map[string]string{"OPUS_BITRATE": "96000", "OPUS_INBAND_FEC": "false", "FFMPEG_RT_BUFFER_SIZE": "64M"},
+			guilds: map[string]map[string]string{},
+		},
+		{
+			name:   "guilds",
+			file:   "audio_volume: 1.5\nguilds:\n  \"123\":\n    audio:\n      volume: 0.5\n  \"456\":\n    sources:\n      other: false\n",
+			values: map[string]string{"AUDIO_VOLUME": "1.5"},
+			guilds: map[string]map[string]string{
+				"123": {"AUDIO_VOLUME": "0.5"},
+				"456": {"SOURCES_OTHER": "false"},
+			},
+		},
+		{
+			name:   "empty guilds section",
+			file:   "guilds:\n",
+			values: map[string]string{},
+			guilds: map[string]map[string]string{},
+		},
+		{
+			name: "set twice",
+			file: "opus_bitrate: 64000\nopus:\n  bitrate: 96000\n",
+			err:  "opus_bitrate is set more than once",
+		},
+		{
+			name: "not a mapping",
+			file: "- bot_token\n",
+			err:  "expected a mapping of settings",
+		},
+		{
+			name: "guilds not a mapping",
+			file: "guilds: 123\n",
+			err:  "guilds must map guild IDs to settings",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			values, guilds, err := readConfigFile(path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error = %v", err)
+			}
+
+			if got := nodeValues(values); !maps.Equal(got, tt.values) {
+				t.Errorf("values = %v, want %v", got, tt.values)
+			}
+			if len(guilds) != len(tt.guilds) {
+				t.Errorf("got %d guilds, want %d", len(guilds), len(tt.guilds))
+			}
+			for guildID, want := range tt.guilds {
+				if got := nodeValues(guilds[guildID]); !maps.Equal(got, want) {
+					t.Errorf("guild %s values = %v, want %v", guildID, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	file := "bot_token: from-file\nopus:\n  complexity: 5\ninactivity_timeout: 60\nvote_skip_percent: 10\n"
+	dotenv := "OPUS_COMPLEXITY=6\nINACTIVITY_TIMEOUT=90\n"
+	env := map[string]string{"INACTIVITY_TIMEOUT": "120"}
+
+	config, err := loadTestConfig(t, file, dotenv, env)
+	if err != nil {
+		t.Fatalf("LoadConfig returned %v", err)
+	}
+
+	tests := []struct {
+		name string
+		got  int
+		want int
+	}{
+		{"file only", config.VoteSkipPercent, 10},
+		{".env over file", config.OpusComplexity, 6},
+		{"environment over .env and file", config.InactivityTimeout, 120},
+		{"default", config.EmptyChannelTimeout, defaultConfig().EmptyChannelTimeout},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, tt.got, tt.want)
+		}
+	}
+	if config.BotToken != "from-file" {
+		t.Errorf("BotToken = %q, want %q", config.BotToken, "from-file")
+	}
+}
+
+func TestLoadConfigGuilds(t *testing.T) {
+	tests := []struct {
+		name    string
+		noToken bool // Leave out the global bot token
+		guilds  string
+		errs    []string
+	}{
+		{
+			name:   "valid override",
+			guilds: "  \"123\":\n    vote_skip_percent: 0\n",
+		},
+		{
+			name:   "cannot be overridden",
+			guilds: "  \"123\":\n    bot_token: other\n",
+			errs:   []string{"BOT_TOKEN (guild 123): cannot be overridden per guild"},
+		},
+		{
+			name:    "cannot be overridden without a global value",
+			noToken: true,
+			guilds:  "  \"123\":\n    bot_token: other\n",
+			errs: []string{
+				"BOT_TOKEN: is required",
+				"BOT_TOKEN (guild 123): cannot be overridden per guild",
+			},
+		},
+		{
+			name:   "out of range",
+			guilds: "  \"123\":\n    opus:\n      complexity: 11\n",
+			errs:   []string{"OPUS_COMPLEXITY (guild 123): 11 is outside valid range (0-10)"},
+		},
+		{
+			name:   "not a number",
+			guilds: "  \"123\":\n    vote_skip_percent: lots\n",
+			errs:   []string{`VOTE_SKIP_PERCENT (guild 123): "lots" is not a valid integer`},
+		},
+		{
+			name:   "unknown setting",
+			guilds: "  \"123\":\n    colour: blue\n",
+			errs:   []string{"COLOUR (guild 123): unknown setting"},
+		},
+		{
+			name:   "not a guild ID",
+			guilds: "  general:\n    vote_skip_percent: 0\n",
+			errs:   []string{`GUILDS: "general" is not a guild ID`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := "vote_skip_percent: 50\nguilds:\n" + tt.guilds
+			if !tt.noToken {
+				file = "bot_token: abc\n" + file
+			}
+			config, err := loadTestConfig(t, file, "", nil)
+
+			var problems ConfigErrors
+			if err != nil && !errors.As(err, &problems) {
+				t.Fatalf("LoadConfig returned %v", err)
+			}
+			if len(problems) != len(tt.errs) {
+				t.Fatalf("got problems %v, want %q", problems, tt.errs)
+			}
+			for i, problem := range problems {
+				if !strings.HasPrefix(problem.Error(), tt.errs[i]) {
+					t.Errorf("problem %d = %q, want %q", i, problem.Error(), tt.errs[i])
+				}
+			}
+
+			if err == nil {
+				if got := config.ForGuild("123").VoteSkipPercent; got != 0 {
+					t.Errorf("guild VoteSkipPercent = %d, want 0", got)
+				}
+				if got := config.ForGuild("456").VoteSkipPercent; got != 50 {
+					t.Errorf("other guild VoteSkipPercent = %d, want 50", got)
+				}
+			}
+		})
+	}
+}
+
+// nodeValues returns the scalar value of each flattened config key.
+func nodeValues(nodes map[string]*yaml.Node) map[string]string {
+	values := make(map[string]string, len(nodes))
+	for key, node := range nodes {
+		values[key] = node.Value
+	}
+	return values
+}
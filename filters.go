@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// audioPreset is a named effect that can be layered on top of the configured
+// filter chain with /filter.
+type audioPreset struct {
+	Name        string
+	Description string
+	Filter      string  // FFmpeg filters added to the chain
+	Speed       float64 // Playback speed relative to the source
+}
+
+// audioPresets lists the presets offered by /filter. Presets that change the
+// speed resample to 48kHz first so asetrate works from a known rate.
+var audioPresets = []audioPreset{
+	{
+		Name:        "bassboost",
+		Description: "Bass boost",
+		Filter:      "bass=g=10:f=110:w=0.6",
+		Speed:       1,
+	},
+	{
+		Name:        "nightcore",
+		Description: "Nightcore",
+		Filter:      "aresample=48000,asetrate=60000,aresample=48000",
+		Speed:       1.25,
+	},
+	{
+		Name:        "vaporwave",
+		Description: "Vaporwave",
+		Filter:      "aresample=48000,asetrate=38400,aresample=48000",
+		Speed:       0.8,
+	},
+	{
+		Name:        "8d",
+		Description: "8D audio",
+		Filter:      "apulsator=hz=0.125",
+		Speed:       1,
+	},
+	{
+		Name:        "karaoke",
+		Description: "Karaoke (vocal removal)",
+		Filter:      "pan=stereo|c0=c0-c1|c1=c1-c0",
+		Speed:       1,
+	},
+	{
+		Name:        "treble",
+		Description: "Treble boost",
+		Filter:      "treble=g=6",
+		Speed:       1,
+	},
+}
+
+func findAudioPreset(name string) *audioPreset {
+	for i := range audioPresets {
+		if audioPresets[i].Name == name {
+			return &audioPresets[i]
+		}
+	}
+	return nil
+}
+
+// eqBands are the center frequencies in Hz of the graphic equalizer bands.
+var eqBands = [...]int{31, 62, 125, 250, 500, 1000, 2000, 4000, 8000, 16000}
+
+// eqMaxGain is the largest boost or cut in dB allowed on a band.
+const eqMaxGain = 12.0
+
+// EQGains holds the gain in dB of each equalizer band.
+type EQGains [len(eqBands)]float64
+
+// ValidateEQGain checks a band gain the same way the configuration checks
+// its ranges.
+func ValidateEQGain(gain float64) error {
+	if math.IsNaN(gain) || gain < -eqMaxGain || gain > eqMaxGain {
+		return fmt.Errorf("gain %.1f dB is outside valid range (%.0f to %.0f)", gain, -eqMaxGain, eqMaxGain)
+	}
+	return nil
+}
+
+// filters returns one octave-wide peaking filter per band that is not flat.
+func (g EQGains) filters() []string {
+	var filters []string
+	for i, gain := range g {
+		if gain == 0 {
+			continue
+		}
+		filters = append(filters, fmt.Sprintf("equalizer=f=%d:t=o:w=1:g=%.1f", eqBands[i], gain))
+	}
+	return filters
+}
+
+// Chart renders the gains as a horizontal ASCII bar chart centered on 0 dB,
+// one character per dB.
+func (g EQGains) Chart() string {
+	const half = int(eqMaxGain)
+
+	var b strings.Builder
+	for i, gain := range g {
+		bar := []rune(strings.Repeat("·", half) + "|" + strings.Repeat("·", half))
+		steps := int(math.Round(math.Abs(gain)))
+		for step := 1; step <= steps; step++ {
+			if gain > 0 {
+				bar[half+step] = '█'
+			} else {
+				bar[half-step] = '█'
+			}
+		}
+		fmt.Fprintf(&b, "%6s %s %+5.1f dB\n", formatFrequency(eqBands[i]), string(bar), gain)
+	}
+	return b.String()
+}
+
+func formatFrequency(hz int) string {
+	if hz >= 1000 {
+		return fmt.Sprintf("%dkHz", hz/1000)
+	}
+	return fmt.Sprintf("%dHz", hz)
+}
+
+// audioEffects are the per-guild additions to the ffmpeg filter chain.
+type audioEffects struct {
+	Filters []string
+	Speed   float64 // Playback speed relative to the source
+}
+
+// newAudioEffects combines the active preset with the equalizer. The
+// equalizer comes last so it shapes the preset's output.
+func newAudioEffects(preset *audioPreset, eq EQGains) audioEffects {
+	fx := audioEffects{Speed: 1}
+	if preset != nil {
+		fx.Filters = append(fx.Filters, preset.Filter)
+		fx.Speed = preset.Speed
+	}
+	fx.Filters = append(fx.Filters, eq.filters()...)
+	return fx
+}
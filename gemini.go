@@ -4,40 +4,42 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sync/atomic"
 	"time"
 
 	"google.golang.org/genai"
 )
 
-var (
-	geminiClient *genai.Client
-)
+// geminiClient is replaced when a reload changes the API key.
+var geminiClient atomic.Pointer[genai.Client]
 
-func initGemini() {
-	config := LoadConfig()
+func initGemini(config *Config) {
 	if config.GeminiAPIKey == "" {
 		log.Println("Gemini API key not found, Gemini features will be disabled.")
 		return
 	}
 
 	ctx := context.Background()
-	client, err := genai.NewClient(ctx, nil)
+	client, err := genai.NewClient(ctx, &genai.ClientConfig{
+		APIKey: config.GeminiAPIKey,
+	})
 	if err != nil {
 		log.Printf("error creating gemini client: %v", err)
 		return
 	}
-	geminiClient = client
+	geminiClient.Store(client)
 }
 
 func generateContent(prompt string) (string, error) {
-	if geminiClient == nil {
+	client := geminiClient.Load()
+	if client == nil {
 		return "", fmt.Errorf("gemini client not initialized")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	result, err := geminiClient.Models.GenerateContent(
+	result, err := client.Models.GenerateContent(
 		ctx,
 		"gemini-2.5-flash",
 		genai.Text(prompt),
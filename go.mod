@@ -9,6 +9,7 @@ require (
 	golang.org/x/oauth2 v0.23.0
 	google.golang.org/genai v1.13.0
 	gopkg.in/hraban/opus.v2 v2.0.0-20230925203106-0188a62cb302
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
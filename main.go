@@ -2,17 +2,22 @@ package main
 
 import (
 	"bufio"
-	"encoding/binary"
+	"cmp"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"math/rand/v2"
 	"os"
 	"os/exec"
 	"os/signal"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -21,40 +26,89 @@ import (
 )
 
 type Bot struct {
-	session *discordgo.Session
-	guilds  map[string]*GuildState
-	mu      sync.RWMutex
+	session     *discordgo.Session
+	cfg         atomic.Pointer[Config]
+	reloadMu    sync.Mutex // Serializes config reloads
+	ownerIDs    map[string]bool
+	guilds      map[string]*GuildState
+	settings    *SettingsStore
+	sessions    *SessionStore
+	stats       *StatsStore
+	nowPlaying  *nowPlayingUpdater
+	restoreOnce sync.Once
+	stop        chan struct{} // Closed by Stop to end background work
+	mu          sync.RWMutex
 }
 
 type GuildState struct {
 	voice         *discordgo.VoiceConnection
 	queue         *Queue
 	skipChan      chan bool
-	done          chan bool
+	seekChan      chan time.Duration
+	stopChan      chan struct{} // Closed to end playback without moving on
 	paused        bool
+	autoPaused    bool // Paused because the voice channel emptied
+	stage         bool // The voice channel is a stage
+	loopMode      LoopMode
+	current       *Song
+	position      time.Duration // Playback position within current
+	volume        int           // Volume in percent applied to the PCM stream
+	crossfade     time.Duration // Overlap between the end of a song and the next
+	preset        *audioPreset  // Active /filter preset, nil when none
+	eq            EQGains
+	skipVotes     map[string]bool // Users who voted to skip current
+	votesNeeded   int             // Votes needed to skip, as of the last vote
+	prefetched    *prefetch
 	nowPlaying    *discordgo.Message
+	requeued      bool // /skipto already put current back in the queue
 	process       *os.Process
 	inactiveTimer *time.Timer
+	emptyTimer    *time.Timer // Leaves the voice channel after everyone left
 	mu            sync.Mutex
 }
 
 type VideoInfo struct {
-	URL      string        `json:"url"`
-	Title    string        `json:"title"`
-	Duration time.Duration `json:"duration"`
+	URL       string        `json:"url"`
+	Title     string        `json:"title"`
+	Duration  time.Duration `json:"duration"`
+	Thumbnail string        `json:"thumbnail"`
+	Uploader  string        `json:"uploader"`
 }
 
+var (
+	configFile  = flag.String("config", "", "path to a YAML config file (default: $CONFIG_FILE, or config.yaml if it exists)")
+	checkConfig = flag.Bool("check-config", false, "report every invalid config value and exit")
+)
+
 func main() {
+	flag.Parse()
+	if *checkConfig {
+		os.Exit(runConfigCheck(*configFile))
+	}
+
 	if err := setupLogging(); err != nil {
 		log.Fatal(err)
 	}
 
-	initSpotify()
-	initGemini()
+	config, err := LoadConfig(*configFile)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	initSpotify(config)
+	initGemini(config)
+
+	settings, err := LoadSettingsStore(config.SettingsFilePath)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	config := LoadConfig()
+	stats, err := LoadStatsStore(config.StatsFilePath)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	bot, err := NewBot(config.BotToken)
+	bot, err := NewBot(config, settings, NewSessionStore(config.SessionFilePath), stats)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -66,29 +120,44 @@ func main() {
 	go checkYtDlpUpdates()
 
 	sc := make(chan os.Signal, 1)
-	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
-	<-sc
+	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, os.Interrupt)
+	for sig := range sc {
+		if sig == syscall.SIGHUP {
+			if err := bot.ReloadConfig(); err != nil {
+				log.Printf("Config reload failed, keeping the current config: %v", err)
+			}
+			continue
+		}
+		break
+	}
 
 	bot.Stop()
 }
 
-func NewBot(token string) (*Bot, error) {
-	if token == "" {
+func NewBot(config *Config, settings *SettingsStore, sessions *SessionStore, stats *StatsStore) (*Bot, error) {
+	if config.BotToken == "" {
 		return nil, fmt.Errorf("bot token not found")
 	}
 
-	dg, err := discordgo.New("Bot " + token)
+	dg, err := discordgo.New("Bot " + config.BotToken)
 	if err != nil {
 		return nil, fmt.Errorf("creating discord session: %w", err)
 	}
 
 	bot := &Bot{
-		session: dg,
-		guilds:  make(map[string]*GuildState),
+		session:    dg,
+		guilds:     make(map[string]*GuildState),
+		settings:   settings,
+		sessions:   sessions,
+		stats:      stats,
+		nowPlaying: newNowPlayingUpdater(),
+		stop:       make(chan struct{}),
 	}
+	bot.cfg.Store(config)
 
 	dg.AddHandler(bot.ready)
 	dg.AddHandler(bot.interactionCreate)
+	dg.AddHandler(bot.voiceStateUpdate)
 	dg.Identify.Intents = discordgo.IntentsGuilds | discordgo.IntentsGuildMessages | discordgo.IntentsGuildVoiceStates
 
 	return bot, nil
@@ -104,10 +173,84 @@ func (b *Bot) Start() error {
 		return fmt.Errorf("registering commands: %w", err)
 	}
 
+	b.ownerIDs = make(map[string]bool)
+	if app, err := b.session.Application("@me"); err != nil {
+		log.Printf("Error looking up application owner: %v", err)
+	} else if app.Team != nil {
+		for _, member := range app.Team.Members {
+			b.ownerIDs[member.User.ID] = true
+		}
+	} else if app.Owner != nil {
+		b.ownerIDs[app.Owner.ID] = true
+	}
+
+	go b.saveSessionsPeriodically(b.stop)
+	go b.runNowPlayingUpdates(b.stop)
+
 	return nil
 }
 
+// config returns the current configuration. Callers should fetch it once per
+// operation so a concurrent reload never mixes old and new values.
+func (b *Bot) config() *Config {
+	return b.cfg.Load()
+}
+
+// guildConfig returns the current configuration with the guild's overrides
+// from the config file applied.
+func (b *Bot) guildConfig(guildID string) *Config {
+	return b.config().ForGuild(guildID)
+}
+
+// ReloadConfig loads and validates the configuration again and swaps it in
+// if it is valid. Songs that are already playing keep the config they started
+// with.
+func (b *Bot) ReloadConfig() error {
+	// Loading changes the environment, so only one reload may run at once.
+	b.reloadMu.Lock()
+	defer b.reloadMu.Unlock()
+
+	config, err := LoadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+
+	old := b.config()
+	if changed := config.startupChanges(old); len(changed) > 0 {
+		return fmt.Errorf("%s cannot change without a restart", strings.Join(changed, ", "))
+	}
+
+	if config.SpotifyClientID != old.SpotifyClientID || config.SpotifyClientSecret != old.SpotifyClientSecret {
+		initSpotify(config)
+	}
+	if config.GeminiAPIKey != old.GeminiAPIKey {
+		initGemini(config)
+	}
+
+	b.cfg.Store(config)
+	log.Println("Config reloaded")
+	return nil
+}
+
+func (b *Bot) handleReloadConfig(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Member == nil || !b.ownerIDs[i.Member.User.ID] {
+		respondEphemeral(s, i, "Only the bot owner can reload the config")
+		return
+	}
+
+	if err := b.ReloadConfig(); err != nil {
+		log.Printf("Config reload failed, keeping the current config: %v", err)
+		respondEphemeral(s, i, fmt.Sprintf("Config reload failed, keeping the current config: %v", err))
+		return
+	}
+	respondEphemeral(s, i, "Config reloaded")
+}
+
 func (b *Bot) Stop() {
+	// Save before tearing anything down so the next start can resume.
+	close(b.stop)
+	b.saveSessions()
+
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
@@ -127,54 +270,67 @@ func (b *Bot) getOrCreateGuildState(guildID string) *GuildState {
 		return state
 	}
 
+	settings := b.settings.Get(guildID)
 	state := &GuildState{
-		queue: NewQueue(),
-	}
+		queue:     NewQueue(),
+		volume:    settings.Volume,
+		loopMode:  settings.LoopMode,
+		crossfade: settings.crossfade(b.guildConfig(guildID)),
+		preset:    findAudioPreset(settings.Preset),
+		eq:        settings.EQ,
+	}
+	state.queue.SetFair(settings.FairQueue)
 	b.guilds[guildID] = state
 	return state
 }
 
 func (b *Bot) ready(s *discordgo.Session, r *discordgo.Ready) {
 	log.Println("Bot is ready")
+
+	// Ready also fires after a full reconnect; only resume saved sessions
+	// on the first one.
+	b.restoreOnce.Do(func() {
+		go b.restoreSessions(s)
+	})
 }
 
 func (b *Bot) handleComponent(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	state := b.getOrCreateGuildState(i.GuildID)
 
-	switch i.MessageComponentData().CustomID {
+	customID := i.MessageComponentData().CustomID
+	if strings.HasPrefix(customID, queuePagePrefix) {
+		b.handleQueuePageButton(s, i, state, strings.TrimPrefix(customID, queuePagePrefix))
+		return
+	}
+
+	if denied := b.checkPermission(s, i, componentPermissions[customID]); denied != "" {
+		respondEphemeral(s, i, denied)
+		return
+	}
+
+	switch customID {
 	case "music_pause":
 		b.handlePauseButton(s, i, state)
 	case "music_skip":
 		b.handleSkipButton(s, i, state)
 	case "music_stop":
 		b.handleStopButton(s, i, state)
+	case "music_loop":
+		b.handleLoopButton(s, i, state)
+	case "music_shuffle":
+		b.handleShuffleButton(s, i, state)
+	case "music_rewind":
+		b.handleSeekButton(s, i, state, -seekStep)
+	case "music_forward":
+		b.handleSeekButton(s, i, state, seekStep)
 	}
 }
 
 func (b *Bot) handleSkip(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	state := b.getOrCreateGuildState(i.GuildID)
 
-	if state.skipChan == nil {
-		respondEphemeral(s, i, "Nothing to skip")
-		return
-	}
-
-	// Acknowledge the interaction immediately.
-	respondEphemeral(s, i, "Skipped the current song")
-
-	state.mu.Lock()
-	if state.paused {
-		state.paused = false
-	}
-	state.mu.Unlock()
-	state.voice.Speaking(true)
-
-	// Non-blocking send to the skip channel.
-	select {
-	case state.skipChan <- true:
-	default:
-		// If the channel is full, a skip is already pending.
-	}
+	_, reply := b.voteSkip(s, i, state)
+	respondEphemeral(s, i, reply)
 }
 
 func (b *Bot) handlePause(s *discordgo.Session, i *discordgo.InteractionCreate) {
@@ -188,6 +344,7 @@ func (b *Bot) handlePause(s *discordgo.Session, i *discordgo.InteractionCreate)
 	}
 
 	state.paused = !state.paused
+	state.autoPaused = false
 	state.voice.Speaking(!state.paused)
 
 	status := "Resumed"
@@ -199,80 +356,477 @@ func (b *Bot) handlePause(s *discordgo.Session, i *discordgo.InteractionCreate)
 
 func (b *Bot) handleStop(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	state := b.getOrCreateGuildState(i.GuildID)
-	respondEphemeral(s, i, "Stopped playing and left the voice channel")
+	if b.settings.Get(i.GuildID).AlwaysOn {
+		respondEphemeral(s, i, "Stopped playing, staying in the voice channel for 24/7 mode")
+	} else {
+		respondEphemeral(s, i, "Stopped playing and left the voice channel")
+	}
 
+	b.stopPlaying(s, i.GuildID, state)
+}
+
+// stopPlaying stops the current song and clears the queue. The bot leaves the
+// voice channel unless 24/7 mode is on.
+func (b *Bot) stopPlaying(s *discordgo.Session, guildID string, state *GuildState) {
 	state.stopPlayback(s)
-	b.disconnectFromGuild(i.GuildID)
+	if !b.settings.Get(guildID).AlwaysOn {
+		b.disconnectFromGuild(guildID)
+	}
 }
 
-func (b *Bot) handlePauseButton(s *discordgo.Session, i *discordgo.InteractionCreate, state *GuildState) {
+func (b *Bot) handleQueue(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	state := b.getOrCreateGuildState(i.GuildID)
+
+	songs := state.queue.List()
+	if len(songs) == 0 {
+		respondEphemeral(s, i, "The queue is empty")
+		return
+	}
+
+	embed, components := buildQueuePage(songs, 0)
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Embeds:     []*discordgo.MessageEmbed{embed},
+			Components: components,
+			Flags:      discordgo.MessageFlagsEphemeral,
+		},
+	})
+}
+
+func (b *Bot) handleRemove(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	state := b.getOrCreateGuildState(i.GuildID)
+	subcommand := i.ApplicationCommandData().Options[0]
+
+	if subcommand.Name == "mine" {
+		removed := state.queue.RemoveWhere(func(song *Song) bool {
+			return song.RequesterID == i.Member.User.ID
+		})
+		state.refreshPrefetch()
+		if len(removed) == 0 {
+			respondEphemeral(s, i, "You have no songs in the queue")
+			return
+		}
+		respondEphemeral(s, i, fmt.Sprintf("Removed %d of your songs from the queue", len(removed)))
+		return
+	}
+
+	position := int(subcommand.Options[0].IntValue())
+
+	canRemoveAny := hasDJAccess(i, b.settings.Get(i.GuildID))
+	song, err := state.queue.RemoveIf(position-1, func(song *Song) error {
+		if canRemoveAny || song.RequesterID == i.Member.User.ID {
+			return nil
+		}
+		return fmt.Errorf("you can only remove songs you requested")
+	})
+	if err != nil {
+		respondEphemeral(s, i, fmt.Sprintf("Error: %v", err))
+		return
+	}
+	state.refreshPrefetch()
+	respondEphemeral(s, i, fmt.Sprintf("Removed from queue: %s", song.Title))
+}
+
+func (b *Bot) handleMove(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	state := b.getOrCreateGuildState(i.GuildID)
+	options := i.ApplicationCommandData().Options
+	from := int(options[0].IntValue())
+	to := int(options[1].IntValue())
+
+	song, err := state.queue.Move(from-1, to-1)
+	if err != nil {
+		respondEphemeral(s, i, fmt.Sprintf("Error: %v", err))
+		return
+	}
+	state.refreshPrefetch()
+	respondEphemeral(s, i, fmt.Sprintf("Moved %s to position %d", song.Title, to))
+}
+
+func (b *Bot) handleSwap(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	state := b.getOrCreateGuildState(i.GuildID)
+	options := i.ApplicationCommandData().Options
+	first := int(options[0].IntValue())
+	second := int(options[1].IntValue())
+
+	if err := state.queue.Swap(first-1, second-1); err != nil {
+		respondEphemeral(s, i, fmt.Sprintf("Error: %v", err))
+		return
+	}
+	state.refreshPrefetch()
+	respondEphemeral(s, i, fmt.Sprintf("Swapped positions %d and %d", first, second))
+}
+
+func (b *Bot) handleClear(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	state := b.getOrCreateGuildState(i.GuildID)
+
+	n := state.queue.Clear()
+	state.refreshPrefetch()
+	if n == 0 {
+		respondEphemeral(s, i, "The queue is already empty")
+		return
+	}
+	respondEphemeral(s, i, fmt.Sprintf("Removed %d songs from the queue", n))
+}
+
+func (b *Bot) handleSkipTo(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	state := b.getOrCreateGuildState(i.GuildID)
+	position := int(i.ApplicationCommandData().Options[0].IntValue())
+
+	// Hold the lock so the current song cannot end and be queued again
+	// while it is moved.
 	state.mu.Lock()
-	defer state.mu.Unlock()
+	if state.current == nil {
+		state.mu.Unlock()
+		respondEphemeral(s, i, "Nothing to skip")
+		return
+	}
+	// When looping the queue, the current song and the ones skipped over
+	// come round again.
+	var current *Song
+	if state.loopMode == LoopQueue {
+		current = state.current
+	}
+	song, err := state.queue.SkipTo(position-1, current)
+	if err == nil && current != nil {
+		state.requeued = true
+	}
+	state.mu.Unlock()
+	if err != nil {
+		respondEphemeral(s, i, fmt.Sprintf("Error: %v", err))
+		return
+	}
+	state.refreshPrefetch()
+
+	if !state.skip() {
+		// Playback ended in the meantime.
+		respondEphemeral(s, i, fmt.Sprintf("%s is next in the queue", song.Title))
+		return
+	}
+	respondEphemeral(s, i, fmt.Sprintf("Skipping to: %s", song.Title))
+}
+
+func (b *Bot) handleShuffle(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	state := b.getOrCreateGuildState(i.GuildID)
+
+	if state.queue.Len() < 2 {
+		respondEphemeral(s, i, "Not enough songs in the queue to shuffle")
+		return
+	}
+
+	state.queue.Shuffle()
+	state.refreshPrefetch()
+	respondEphemeral(s, i, "Shuffled the queue")
+}
+
+func (b *Bot) handleSeek(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	state := b.getOrCreateGuildState(i.GuildID)
+
+	target, err := parseTimestamp(i.ApplicationCommandData().Options[0].StringValue())
+	if err != nil {
+		respondEphemeral(s, i, fmt.Sprintf("Error: %v", err))
+		return
+	}
+
+	if err := state.seek(target); err != nil {
+		respondEphemeral(s, i, fmt.Sprintf("Error: %v", err))
+		return
+	}
+	respondEphemeral(s, i, fmt.Sprintf("Seeked to %s", formatDuration(target)))
+}
+
+func (b *Bot) handleVolume(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	state := b.getOrCreateGuildState(i.GuildID)
+
+	options := i.ApplicationCommandData().Options
+	if len(options) == 0 {
+		state.mu.Lock()
+		volume := state.volume
+		state.mu.Unlock()
+		respondEphemeral(s, i, fmt.Sprintf("Volume: %d%%", volume))
+		return
+	}
+
+	volume := int(options[0].IntValue())
+	if volume < 0 || volume > maxVolume {
+		respondEphemeral(s, i, fmt.Sprintf("Volume must be between 0 and %d", maxVolume))
+		return
+	}
+
+	state.mu.Lock()
+	state.volume = volume
+	state.mu.Unlock()
+
+	if err := b.settings.Update(i.GuildID, func(gs *GuildSettings) {
+		gs.Volume = volume
+	}); err != nil {
+		log.Printf("Error saving guild settings: %v", err)
+	}
 
+	respondEphemeral(s, i, fmt.Sprintf("Volume set to %d%%", volume))
+}
+
+func (b *Bot) handleCrossfade(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	state := b.getOrCreateGuildState(i.GuildID)
+
+	options := i.ApplicationCommandData().Options
+	if len(options) == 0 {
+		state.mu.Lock()
+		crossfade := state.crossfade
+		state.mu.Unlock()
+		if crossfade == 0 {
+			respondEphemeral(s, i, "Crossfade is off")
+		} else {
+			respondEphemeral(s, i, fmt.Sprintf("Crossfade: %d seconds", int(crossfade/time.Second)))
+		}
+		return
+	}
+
+	seconds := int(options[0].IntValue())
+	if seconds < 0 || seconds > maxCrossfadeSeconds {
+		respondEphemeral(s, i, fmt.Sprintf("Crossfade must be between 0 and %d seconds", maxCrossfadeSeconds))
+		return
+	}
+
+	state.mu.Lock()
+	state.crossfade = time.Duration(seconds) * time.Second
+	state.mu.Unlock()
+
+	if err := b.settings.Update(i.GuildID, func(gs *GuildSettings) {
+		gs.Crossfade = &seconds
+	}); err != nil {
+		log.Printf("Error saving guild settings: %v", err)
+	}
+
+	if seconds == 0 {
+		respondEphemeral(s, i, "Crossfade turned off")
+	} else {
+		respondEphemeral(s, i, fmt.Sprintf("Crossfade set to %d seconds", seconds))
+	}
+}
+
+func (b *Bot) handleFilter(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	state := b.getOrCreateGuildState(i.GuildID)
+
+	options := i.ApplicationCommandData().Options
+	if len(options) == 0 {
+		state.mu.Lock()
+		preset := state.preset
+		state.mu.Unlock()
+		if preset == nil {
+			respondEphemeral(s, i, "No filter is active")
+		} else {
+			respondEphemeral(s, i, fmt.Sprintf("Active filter: %s", preset.Description))
+		}
+		return
+	}
+
+	name := options[0].StringValue()
+	var preset *audioPreset
+	if name != "clear" {
+		if preset = findAudioPreset(name); preset == nil {
+			respondEphemeral(s, i, fmt.Sprintf("Unknown filter %q", name))
+			return
+		}
+	}
+
+	state.setEffects(func() {
+		state.preset = preset
+	})
+
+	if preset == nil {
+		respondEphemeral(s, i, "Filter cleared")
+	} else {
+		respondEphemeral(s, i, fmt.Sprintf("Filter set to %s", preset.Description))
+	}
+}
+
+func (b *Bot) handleEQ(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	state := b.getOrCreateGuildState(i.GuildID)
+	subcommand := i.ApplicationCommandData().Options[0]
+
+	var eq EQGains
+	switch subcommand.Name {
+	case "show":
+		state.mu.Lock()
+		eq = state.eq
+		state.mu.Unlock()
+		respondEphemeral(s, i, "```\n"+eq.Chart()+"```")
+		return
+
+	case "set":
+		band := int(subcommand.Options[0].IntValue())
+		gain := subcommand.Options[1].FloatValue()
+		if band < 0 || band >= len(eqBands) {
+			respondEphemeral(s, i, fmt.Sprintf("Band must be between 0 and %d", len(eqBands)-1))
+			return
+		}
+		if err := ValidateEQGain(gain); err != nil {
+			respondEphemeral(s, i, fmt.Sprintf("Error: %v", err))
+			return
+		}
+		state.setEffects(func() {
+			state.eq[band] = gain
+			eq = state.eq
+		})
+
+	case "reset":
+		state.setEffects(func() {
+			state.eq = EQGains{}
+		})
+	}
+
+	if err := b.settings.Update(i.GuildID, func(gs *GuildSettings) {
+		gs.EQ = eq
+	}); err != nil {
+		log.Printf("Error saving guild settings: %v", err)
+	}
+
+	respondEphemeral(s, i, "Equalizer updated\n```\n"+eq.Chart()+"```")
+}
+
+func (b *Bot) handleLoop(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	state := b.getOrCreateGuildState(i.GuildID)
+
+	state.mu.Lock()
+	mode := state.loopMode.Next()
+	if options := i.ApplicationCommandData().Options; len(options) > 0 {
+		var err error
+		mode, err = ParseLoopMode(options[0].StringValue())
+		if err != nil {
+			state.mu.Unlock()
+			respondEphemeral(s, i, fmt.Sprintf("Error: %v", err))
+			return
+		}
+	}
+	state.loopMode = mode
+	state.mu.Unlock()
+	state.refreshPrefetch()
+
+	respondEphemeral(s, i, fmt.Sprintf("Loop mode: %s", mode))
+}
+
+func (b *Bot) handleQueuePageButton(s *discordgo.Session, i *discordgo.InteractionCreate, state *GuildState, pageArg string) {
+	page, err := strconv.Atoi(pageArg)
+	if err != nil {
+		return
+	}
+
+	songs := state.queue.List()
+	data := &discordgo.InteractionResponseData{
+		Content:    "",
+		Embeds:     []*discordgo.MessageEmbed{},
+		Components: []discordgo.MessageComponent{},
+	}
+	if len(songs) == 0 {
+		data.Content = "The queue is empty"
+	} else {
+		embed, components := buildQueuePage(songs, page)
+		data.Embeds = []*discordgo.MessageEmbed{embed}
+		data.Components = components
+	}
+
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: data,
+	})
+}
+
+func (b *Bot) handlePauseButton(s *discordgo.Session, i *discordgo.InteractionCreate, state *GuildState) {
+	state.mu.Lock()
 	if state.voice == nil {
+		state.mu.Unlock()
 		return
 	}
 
 	state.paused = !state.paused
+	state.autoPaused = false
 	state.voice.Speaking(!state.paused)
+	state.mu.Unlock()
 
-	// Update button emoji
-	emojiName := "⏸️"
-	if state.paused {
-		emojiName = "▶️"
-	}
+	respondNowPlaying(s, i, state)
+}
 
-	components := i.Message.Components
-	if len(components) > 0 {
-		if row, ok := components[0].(*discordgo.ActionsRow); ok {
-			for _, component := range row.Components {
-				if button, ok := component.(*discordgo.Button); ok && button.CustomID == "music_pause" {
-					button.Emoji.Name = emojiName
-				}
-			}
-		}
+func (b *Bot) handleLoopButton(s *discordgo.Session, i *discordgo.InteractionCreate, state *GuildState) {
+	state.mu.Lock()
+	state.loopMode = state.loopMode.Next()
+	state.mu.Unlock()
+	state.refreshPrefetch()
+
+	respondNowPlaying(s, i, state)
+}
+
+// respondNowPlaying updates the message a button was pressed on to show the
+// new playback state. Between songs the embed is kept as it was.
+func respondNowPlaying(s *discordgo.Session, i *discordgo.InteractionCreate, state *GuildState) {
+	state.mu.Lock()
+	song := state.current
+	elapsed := state.position
+	state.mu.Unlock()
+
+	embeds := i.Message.Embeds
+	if song != nil {
+		embeds = []*discordgo.MessageEmbed{buildNowPlaying(song, elapsed, state.status(), state.queue.List())}
 	}
 
 	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseUpdateMessage,
 		Data: &discordgo.InteractionResponseData{
 			Content:    i.Message.Content,
-			Components: components,
+			Embeds:     embeds,
+			Components: state.controls(),
 		},
 	})
 }
 
-func (b *Bot) handleSkipButton(s *discordgo.Session, i *discordgo.InteractionCreate, state *GuildState) {
-	if state.skipChan == nil {
-		return // Or respond with an error
-	}
+func (b *Bot) handleShuffleButton(s *discordgo.Session, i *discordgo.InteractionCreate, state *GuildState) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredMessageUpdate,
+	})
+	state.queue.Shuffle()
+	state.refreshPrefetch()
+}
 
-	// Acknowledge the interaction immediately.
+func (b *Bot) handleSeekButton(s *discordgo.Session, i *discordgo.InteractionCreate, state *GuildState, delta time.Duration) {
 	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseDeferredMessageUpdate,
 	})
 
 	state.mu.Lock()
-	if state.paused {
-		state.paused = false
-		state.voice.Speaking(true)
+	if state.current == nil {
+		state.mu.Unlock()
+		return
 	}
+	target := max(0, state.position+delta)
+	pastEnd := state.current.Duration > 0 && target >= state.current.Duration
 	state.mu.Unlock()
 
-	// Non-blocking send to the skip channel.
-	select {
-	case state.skipChan <- true:
-	default:
-		// If the channel is full, a skip is already pending.
+	// Fast-forwarding past the end behaves like a skip.
+	if pastEnd {
+		state.skip()
+		return
 	}
+	state.seek(target)
+}
+
+func (b *Bot) handleSkipButton(s *discordgo.Session, i *discordgo.InteractionCreate, state *GuildState) {
+	skipped, reply := b.voteSkip(s, i, state)
+	if !skipped {
+		// Let the voter know where the vote stands.
+		respondEphemeral(s, i, reply)
+		return
+	}
+
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredMessageUpdate,
+	})
 }
 
 func (b *Bot) handleStopButton(s *discordgo.Session, i *discordgo.InteractionCreate, state *GuildState) {
 	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseDeferredMessageUpdate,
 	})
-	state.stopPlayback(s)
-	b.disconnectFromGuild(i.GuildID)
+	b.stopPlaying(s, i.GuildID, state)
 }
 
 func (b *Bot) disconnectFromGuild(guildID string) {
@@ -285,85 +839,6 @@ func (b *Bot) disconnectFromGuild(guildID string) {
 	}
 }
 
-func (b *Bot) updateNowPlaying(s *discordgo.Session, state *GuildState, song *Song, done <-chan bool) {
-	ticker := time.NewTicker(time.Second)
-	defer ticker.Stop()
-
-	startTime := time.Now()
-	var pausedTime time.Time
-	var totalPausedDuration time.Duration
-
-	for {
-		select {
-		case <-ticker.C:
-			state.mu.Lock()
-			if state.nowPlaying == nil {
-				state.mu.Unlock()
-				return
-			}
-			paused := state.paused
-			state.mu.Unlock()
-
-			// Handle pause timing
-			if paused {
-				if pausedTime.IsZero() {
-					pausedTime = time.Now()
-				}
-				continue
-			} else if !pausedTime.IsZero() {
-				totalPausedDuration += time.Since(pausedTime)
-				pausedTime = time.Time{}
-			}
-
-			content := formatNowPlaying(song, time.Since(startTime)-totalPausedDuration)
-
-			// Add queue info
-			queueList := state.queue.List()
-			if len(queueList) > 0 {
-				content += "\n\n**Queue:**\n"
-				for i, qSong := range queueList {
-					if i >= 5 {
-						content += fmt.Sprintf("... and %d more", len(queueList)-5)
-						break
-					}
-					content += fmt.Sprintf("%d. %s\n", i+1, qSong.Title)
-				}
-			}
-
-			// Determine components
-			components := musicButtonsNoSkip
-			if !state.queue.IsEmpty() {
-				components = musicButtons
-			}
-
-			s.ChannelMessageEditComplex(&discordgo.MessageEdit{
-				Content:    &content,
-				Components: &components,
-				ID:         state.nowPlaying.ID,
-				Channel:    state.nowPlaying.ChannelID,
-			})
-
-		case <-done:
-			return
-		}
-	}
-}
-
-func resolveSpotifyURL(url, channelID string) ([]*Song, error) {
-	if strings.Contains(url, "track") {
-		// It's a single track
-		track, err := getSpotifyTrack(url)
-		if err != nil {
-			return nil, err
-		}
-		return []*Song{track}, nil
-	} else if strings.Contains(url, "playlist") {
-		// It's a playlist
-		return getSpotifyPlaylist(url, channelID)
-	}
-	return nil, fmt.Errorf("unsupported Spotify URL")
-}
-
 func (b *Bot) interactionCreate(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	switch i.Type {
 	case discordgo.InteractionApplicationCommand:
@@ -371,10 +846,19 @@ func (b *Bot) interactionCreate(s *discordgo.Session, i *discordgo.InteractionCr
 	case discordgo.InteractionMessageComponent:
 		b.handleComponent(s, i)
 	}
+
+	// Most interactions change something the now-playing message shows.
+	b.nowPlaying.markDirty(i.GuildID)
 }
 
 func (b *Bot) handleCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	switch i.ApplicationCommandData().Name {
+	name := i.ApplicationCommandData().Name
+	if denied := b.checkPermission(s, i, commandPermissions[name]); denied != "" {
+		respondEphemeral(s, i, denied)
+		return
+	}
+
+	switch name {
 	case "play":
 		b.handlePlay(s, i)
 	case "skip":
@@ -385,6 +869,40 @@ func (b *Bot) handleCommand(s *discordgo.Session, i *discordgo.InteractionCreate
 		b.handleStop(s, i)
 	case "dj":
 		b.handleDJ(s, i)
+	case "queue":
+		b.handleQueue(s, i)
+	case "remove":
+		b.handleRemove(s, i)
+	case "move":
+		b.handleMove(s, i)
+	case "swap":
+		b.handleSwap(s, i)
+	case "clear":
+		b.handleClear(s, i)
+	case "skipto":
+		b.handleSkipTo(s, i)
+	case "loop":
+		b.handleLoop(s, i)
+	case "shuffle":
+		b.handleShuffle(s, i)
+	case "seek":
+		b.handleSeek(s, i)
+	case "volume":
+		b.handleVolume(s, i)
+	case "crossfade":
+		b.handleCrossfade(s, i)
+	case "filter":
+		b.handleFilter(s, i)
+	case "eq":
+		b.handleEQ(s, i)
+	case "settings":
+		b.handleSettings(s, i)
+	case "stats":
+		b.handleStats(s, i)
+	case "247":
+		b.handleAlwaysOn(s, i)
+	case "reload-config":
+		b.handleReloadConfig(s, i)
 	}
 }
 
@@ -398,7 +916,7 @@ func (b *Bot) handlePlay(s *discordgo.Session, i *discordgo.InteractionCreate) {
 		return
 	}
 
-	songs, err := b.resolveQuery(query, i.ChannelID)
+	songs, err := b.resolveQuery(query, i.ChannelID, b.guildConfig(i.GuildID))
 	if err != nil {
 		editResponse(s, i, fmt.Sprintf("Error: %v", err))
 		return
@@ -421,7 +939,7 @@ func (b *Bot) handleDJ(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	}
 
 	go func() {
-		config := LoadConfig()
+		config := b.guildConfig(i.GuildID)
 		promptTemplate, err := os.ReadFile(config.DJPromptFilePath)
 		if err != nil {
 			editResponse(s, i, "Error: could not load DJ prompt file.")
@@ -447,7 +965,7 @@ func (b *Bot) handleDJ(s *discordgo.Session, i *discordgo.InteractionCreate) {
 			wg.Add(1)
 			go func(q string) {
 				defer wg.Done()
-				resolvedSongs, err := b.resolveQuery(q, i.ChannelID)
+				resolvedSongs, err := b.resolveQuery(q, i.ChannelID, config)
 				if err != nil {
 					log.Printf("could not resolve song query '%s': %v", q, err)
 					return
@@ -482,14 +1000,53 @@ func (b *Bot) enqueueAndPlay(s *discordgo.Session, i *discordgo.InteractionCreat
 		return
 	}
 
-	if err := b.ensureVoiceConnection(s, i.GuildID, voiceChannelID, state); err != nil {
+	notice, err := b.ensureVoiceConnection(s, i.GuildID, voiceChannelID, state)
+	if errors.Is(err, errCannotSpeak) {
+		editResponse(s, i, fmt.Sprintf("Error: %v", err))
+		return
+	}
+	if err != nil {
 		editResponse(s, i, "Error joining voice channel")
 		return
 	}
+	if notice != "" {
+		defer s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+			Content: notice,
+		})
+	}
+
+	truncated := 0
+	limit := b.settings.Get(i.GuildID).maxQueueLength(b.guildConfig(i.GuildID))
+	if limit > 0 {
+		room := limit - state.queue.Len()
+		if room <= 0 {
+			editResponse(s, i, fmt.Sprintf("The queue is full (%d songs)", limit))
+			return
+		}
+		if len(songs) > room {
+			truncated = len(songs) - room
+			songs = songs[:room]
+		}
+	}
 
+	member := *i.Member
+	member.GuildID = i.GuildID // Needed to resolve guild-specific avatars
 	for _, song := range songs {
+		song.RequesterID = member.User.ID
+		song.RequesterName = member.DisplayName()
+		song.RequesterAvatar = member.AvatarURL("128")
 		state.queue.Add(song)
 	}
+	b.stats.RecordRequests(i.GuildID, songs)
+	state.refreshPrefetch()
+	b.nowPlaying.markDirty(i.GuildID)
+
+	if truncated > 0 {
+		defer s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("The queue is limited to %d songs, %d were not added.", limit, truncated),
+			Flags:   discordgo.MessageFlagsEphemeral,
+		})
+	}
 
 	if state.process == nil {
 		if len(songs) > 1 {
@@ -497,7 +1054,7 @@ func (b *Bot) enqueueAndPlay(s *discordgo.Session, i *discordgo.InteractionCreat
 		} else {
 			editResponse(s, i, fmt.Sprintf("Playing: %s", songs[0].Title))
 		}
-		go b.playNext(s, i.GuildID, nil)
+		go b.playNext(s, i.GuildID, nil, false)
 	} else {
 		if len(songs) > 1 {
 			editResponse(s, i, fmt.Sprintf("Added %d songs to the queue.", len(songs)))
@@ -507,12 +1064,16 @@ func (b *Bot) enqueueAndPlay(s *discordgo.Session, i *discordgo.InteractionCreat
 	}
 }
 
-func (b *Bot) resolveQuery(query, channelID string) ([]*Song, error) {
+func (b *Bot) resolveQuery(query, channelID string, config *Config) ([]*Song, error) {
 	var songs []*Song
 
-	// Handle Spotify URLs first
-	if strings.Contains(query, "spotify.com") {
-		return resolveSpotifyURL(query, channelID)
+	if err := config.CheckSource(query); err != nil {
+		return nil, err
+	}
+
+	// Handle Spotify links first
+	if isSpotifyLink(query) {
+		return resolveSpotifyURL(query, channelID, config.SpotifyMarket)
 	}
 
 	// Check if it's a playlist or a single video
@@ -534,6 +1095,8 @@ func (b *Bot) resolveQuery(query, channelID string) ([]*Song, error) {
 			URL:       info.URL,
 			Title:     info.Title,
 			Duration:  info.Duration,
+			Thumbnail: info.Thumbnail,
+			Uploader:  info.Uploader,
 			ChannelID: channelID,
 		})
 	}
@@ -541,171 +1104,253 @@ func (b *Bot) resolveQuery(query, channelID string) ([]*Song, error) {
 	return songs, nil
 }
 
-func (b *Bot) ensureVoiceConnection(s *discordgo.Session, guildID, channelID string, state *GuildState) error {
+// ensureVoiceConnection joins channelID unless the bot is already connected.
+// On a stage it also steps onto the stage; the notice, if any, says what
+// people still have to do to hear it.
+func (b *Bot) ensureVoiceConnection(s *discordgo.Session, guildID, channelID string, state *GuildState) (string, error) {
 	state.mu.Lock()
 	defer state.mu.Unlock()
 
 	if state.voice != nil {
-		return nil
+		return "", nil
 	}
 
 	vc, err := s.ChannelVoiceJoin(guildID, channelID, false, true)
 	if err != nil {
-		return err
+		return "", err
+	}
+
+	stage, notice, err := joinStage(s, guildID, channelID)
+	if err != nil {
+		vc.Disconnect()
+		return "", err
 	}
 
 	state.voice = vc
+	state.stage = stage
 	state.cancelInactivityTimer()
-	return nil
+	return notice, nil
 }
 
-func (b *Bot) playNext(s *discordgo.Session, guildID string, lastSong *Song) {
-	state := b.getOrCreateGuildState(guildID)
+// playNext starts the next song in the queue. lastSong is the song that just
+// ended, or nil if it failed to play; skipped reports whether it was cut short
+// by a skip, which moves past it even when repeating the track.
+func (b *Bot) playNext(s *discordgo.Session, guildID string, lastSong *Song, skipped bool) {
+	b.mu.RLock()
+	state, ok := b.guilds[guildID]
+	b.mu.RUnlock()
+	if !ok {
+		// The bot left the guild while the last song was playing.
+		return
+	}
+
+	if lastSong != nil {
+		switch state.getLoopMode() {
+		case LoopTrack:
+			if !skipped {
+				b.playSound(s, guildID, lastSong, 0)
+				return
+			}
+		case LoopQueue:
+			if !state.takeRequeued() {
+				state.queue.Add(lastSong)
+			}
+		}
+	}
 
 	song := state.queue.Get()
+	if song == nil && lastSong != nil {
+		song = b.queueAutoplay(guildID, state, lastSong)
+	}
 	if song == nil {
 		state.stopPlayback(s)
-		state.startInactivityTimer(func() {
+		if b.settings.Get(guildID).AlwaysOn {
+			// Wait in the channel for the next song.
+			return
+		}
+		timeout := b.settings.Get(guildID).inactivityTimeout(b.guildConfig(guildID))
+		state.startInactivityTimer(timeout, func() {
 			b.disconnectFromGuild(guildID)
 		})
 		return
 	}
 
-	b.playSound(s, guildID, song)
+	b.playSound(s, guildID, song, 0)
 }
 
-func (b *Bot) playSound(s *discordgo.Session, guildID string, song *Song) {
-	state := b.getOrCreateGuildState(guildID)
-	config := LoadConfig()
-
-	components := musicButtons
-	if state.queue.IsEmpty() {
-		components = musicButtonsNoSkip
+// announceChannel returns the channel for messages about song: the guild's
+// announce channel if one is set, otherwise where the song was requested.
+func (b *Bot) announceChannel(guildID string, song *Song) string {
+	if channelID := b.settings.Get(guildID).AnnounceChannelID; channelID != "" {
+		return channelID
 	}
+	return song.ChannelID
+}
 
-	content := formatNowPlaying(song, 0)
-
-	var msg *discordgo.Message
-	var err error
+// playSound plays song from offset, then moves on to the next one.
+func (b *Bot) playSound(s *discordgo.Session, guildID string, song *Song, offset time.Duration) {
+	state := b.getOrCreateGuildState(guildID)
+	config := b.guildConfig(guildID)
 
-	if state.nowPlaying != nil {
-		msg, err = s.ChannelMessageEditComplex(&discordgo.MessageEdit{
-			Content:    &content,
-			Components: &components,
-			ID:         state.nowPlaying.ID,
-			Channel:    state.nowPlaying.ChannelID,
-		})
-	} else {
-		msg, err = s.ChannelMessageSendComplex(song.ChannelID, &discordgo.MessageSend{
-			Content:    content,
+	// An existing now-playing message is switched to this song by the
+	// updater once it starts; otherwise post a new one.
+	state.mu.Lock()
+	posted := state.nowPlaying != nil
+	state.mu.Unlock()
+	if !posted {
+		components := state.controls()
+		embeds := []*discordgo.MessageEmbed{buildNowPlaying(song, offset, state.status(), state.queue.List())}
+		msg, err := s.ChannelMessageSendComplex(b.announceChannel(guildID, song), &discordgo.MessageSend{
+			Embeds:     embeds,
 			Components: components,
 		})
+		if err != nil {
+			log.Printf("Error sending now playing message: %v", err)
+		} else {
+			state.mu.Lock()
+			duplicate := state.nowPlaying != nil
+			if !duplicate {
+				state.nowPlaying = msg
+			}
+			state.mu.Unlock()
+			if duplicate {
+				// Another message was posted while this one was sent.
+				s.ChannelMessageDelete(msg.ChannelID, msg.ID)
+			}
+		}
 	}
 
-	if err != nil {
-		log.Printf("Error sending/editing now playing message: %v", err)
-	} else {
-		state.nowPlaying = msg
+	var t *track
+	if offset == 0 {
+		t = state.takePrefetched(song)
 	}
+	if t == nil {
+		streamURL, err := getStreamURL(song.URL, config)
+		if err != nil {
+			log.Printf("Error getting stream URL: %v", err)
+			s.ChannelMessageSend(b.announceChannel(guildID, song), "Error getting audio stream.")
+			b.playNext(s, guildID, nil, false)
+			return
+		}
 
-	streamURL, err := getStreamURL(song.URL, config)
-	if err != nil {
-		log.Printf("Error getting stream URL: %v", err)
-		s.ChannelMessageSend(song.ChannelID, "Error getting audio stream.")
-		b.playNext(s, guildID, song)
-		return
-	}
+		state.mu.Lock()
+		fx := state.effects()
+		state.mu.Unlock()
 
-	ffmpegArgs := []string{
-		"-reconnect", "1",
-		"-reconnect_streamed", "1",
-		"-reconnect_delay_max", fmt.Sprintf("%d", config.FFmpegReconnectDelay),
-		"-nostdin",
-		"-i", streamURL,
-		"-f", "s16le",
-		"-ar", "48000",
-		"-ac", "2",
+		t, err = startTrack(song, streamURL, offset, fx, config)
+		if err != nil {
+			log.Printf("Error starting ffmpeg: %v", err)
+			b.playNext(s, guildID, nil, false)
+			return
+		}
 	}
 
-	audioFilter := config.BuildAudioFilter()
-	if audioFilter != "" {
-		ffmpegArgs = append(ffmpegArgs, "-af", audioFilter)
+	state.mu.Lock()
+	state.current = song
+	state.requeued = false
+	state.position = t.position
+	state.skipVotes = nil
+	state.skipChan = make(chan bool, 1)
+	state.seekChan = make(chan time.Duration, 1)
+	state.stopChan = make(chan struct{})
+	stop := state.stopChan
+	var stageID string
+	if state.stage && state.voice != nil {
+		stageID = state.voice.ChannelID
 	}
+	state.mu.Unlock()
 
-	ffmpegArgs = append(ffmpegArgs, "pipe:1")
-
-	ffmpeg := exec.Command("ffmpeg", ffmpegArgs...)
+	// Auto-pause only applies while something is playing, so see whether
+	// anyone is listening now that this song is.
+	b.checkListeners(s, guildID, state)
 
-	// Set process priority for real-time audio
-	ffmpeg.SysProcAttr = &syscall.SysProcAttr{
-		// On Linux, set nice value for higher priority
-		// Nice: -10, // Requires root
+	b.nowPlaying.track(guildID)
+	if stageID != "" {
+		go setStageTopic(s, stageID, song)
 	}
 
-	ffmpegErr, err := ffmpeg.StderrPipe()
-	if err != nil {
-		log.Printf("Error getting ffmpeg stderr pipe: %v", err)
-		b.playNext(s, guildID, song)
-		return
+	if offset == 0 {
+		b.stats.RecordPlay(guildID, song)
 	}
 
-	ffmpegOut, err := ffmpeg.StdoutPipe()
-	if err != nil {
-		log.Printf("Error getting ffmpeg stdout pipe: %v", err)
-		b.playNext(s, guildID, song)
-		return
-	}
+	// A seek stops streaming with the new position already recorded, and so
+	// does a dead voice connection, so restart ffmpeg from there until the
+	// song ends or is skipped.
+	var result streamResult
+	failed := false
+	lost := false
+	for {
+		state.mu.Lock()
+		state.process = t.cmd.Process
+		vc := state.voice
+		state.mu.Unlock()
+		if vc == nil {
+			// Left the channel while restarting.
+			t.stop()
+			break
+		}
 
-	// Enhanced error logging with filter
-	go func() {
-		scanner := bufio.NewScanner(ffmpegErr)
-		for scanner.Scan() {
-			line := scanner.Text()
-			// Filter out common non-error messages
-			if !strings.Contains(line, "Press [q] to stop") &&
-				!strings.Contains(line, "size=") &&
-				!strings.Contains(line, "time=") {
-				log.Printf("[ffmpeg] %s", line)
+		result = b.streamAudio(vc, t, state, config)
+		t.stop()
+		if result == streamStalled {
+			if !b.reconnectVoice(s, guildID, state) {
+				lost = true
+				break
 			}
+			if isClosed(stop) {
+				break
+			}
+			log.Printf("Voice connection in guild %s restored, resuming", guildID)
+		} else if result != streamSeeked {
+			break
 		}
-	}()
-
-	if err := ffmpeg.Start(); err != nil {
-		log.Printf("Error starting ffmpeg: %v", err)
-		b.playNext(s, guildID, song)
-		return
-	}
-	log.Println("ffmpeg started with optimized settings")
-
-	state.mu.Lock()
-	state.process = ffmpeg.Process
-	state.skipChan = make(chan bool, 1)
-	if state.done != nil {
-		close(state.done)
-	}
-	state.done = make(chan bool)
-	state.mu.Unlock()
 
-	go b.updateNowPlaying(s, state, song, state.done)
+		state.mu.Lock()
+		offset := state.position
+		fx := state.effects()
+		state.mu.Unlock()
 
-	b.streamAudio(state.voice, ffmpegOut, state, config)
-
-	err = ffmpeg.Wait()
-	if err != nil && err.Error() != "signal: killed" {
-		log.Printf("ffmpeg error: %v", err)
+		var err error
+		t, err = startTrack(song, t.streamURL, offset, fx, config)
+		if err != nil {
+			log.Printf("Error restarting ffmpeg: %v", err)
+			failed = true
+			break
+		}
 	}
 
 	state.mu.Lock()
 	state.process = nil
+	state.current = nil
 	if state.skipChan != nil {
 		close(state.skipChan)
 		state.skipChan = nil
 	}
+	state.seekChan = nil
+	state.stopChan = nil
 	state.mu.Unlock()
 
 	log.Println("playSound finished")
 
-	b.playNext(s, guildID, song)
+	if isClosed(stop) || !b.isCurrentState(guildID, state) {
+		// Stopped on purpose, or the bot has left and may since have
+		// rejoined with a new state; either way this song is done.
+		return
+	}
+
+	if lost {
+		log.Printf("Lost the voice connection in guild %s", guildID)
+		s.ChannelMessageSend(b.announceChannel(guildID, song), "Lost the voice connection and could not rejoin, stopping playback.")
+		state.stopPlayback(s)
+		b.disconnectFromGuild(guildID)
+		return
+	}
+	if failed {
+		// Don't loop a song that can no longer be played.
+		b.playNext(s, guildID, nil, false)
+		return
+	}
+	b.playNext(s, guildID, song, result == streamSkipped)
 }
 
 func createOpusEncoder(config *Config) (*opus.Encoder, error) {
@@ -724,33 +1369,63 @@ func createOpusEncoder(config *Config) (*opus.Encoder, error) {
 	return encoder, nil
 }
 
-func (b *Bot) streamAudio(vc *discordgo.VoiceConnection, audio io.ReadCloser, state *GuildState, config *Config) {
-	const (
-		channels  = 2
-		frameRate = 48000
-		frameSize = 960
-		maxBytes  = (frameSize * channels * 2)
-	)
-	defer audio.Close()
+// streamResult describes why streamAudio stopped.
+type streamResult int
+
+const (
+	streamEnded   streamResult = iota // The audio ran out or failed
+	streamSkipped                     // The song was skipped
+	streamSeeked                      // A seek moved the playback position
+	streamStalled                     // The voice connection stopped taking audio
+	streamStopped                     // Playback was stopped
+)
+
+// streamAudio encodes PCM from t and sends it to the voice connection,
+// advancing state.position as frames are sent, until the track ends, is
+// interrupted by a skip or seek, or the voice connection stops taking audio.
+// Once the end of the song is near, the next one is prefetched, and when
+// crossfade is on its first seconds are mixed into the tail of this one. The
+// next song then picks up where the fade left its track.
+func (b *Bot) streamAudio(vc *discordgo.VoiceConnection, t *track, state *GuildState, config *Config) streamResult {
+	const maxBytes = pcmFrameSize * pcmChannels * 2
 
 	encoder, err := createOpusEncoder(config)
 	if err != nil {
 		log.Printf("Error creating opus encoder: %v", err)
-		return
+		return streamEnded
 	}
 
 	vc.Speaking(true)
 	defer vc.Speaking(false)
 
+	state.mu.Lock()
+	gain := float64(state.volume) / 100
+	stop := state.stopChan
+	state.mu.Unlock()
+
+	// Crossfade progress; fadeNext is nil until the fade starts.
+	var fadeNext *track
+	var fadeLen, fadePos time.Duration
+
 readLoop:
 	for {
 		select {
+		case <-stop:
+			return streamStopped
 		case <-state.skipChan:
 			log.Println("Song skipped")
-			return
+			return streamSkipped
+		case target := <-state.seekChan:
+			log.Printf("Seeking to %s", formatDuration(target))
+			state.mu.Lock()
+			state.position = target
+			state.mu.Unlock()
+			return streamSeeked
 		default:
 			state.mu.Lock()
 			paused := state.paused
+			targetGain := float64(state.volume) / 100
+			crossfade := state.crossfade
 			state.mu.Unlock()
 
 			if paused {
@@ -758,17 +1433,35 @@ readLoop:
 				continue
 			}
 
-			// Read PCM data exactly as original
-			pcm := make([]int16, frameSize*channels)
-			err = binary.Read(audio, binary.LittleEndian, &pcm)
-			if err == io.EOF || err == io.ErrUnexpectedEOF {
+			pcm := t.readFrame()
+			if pcm == nil {
 				break readLoop
 			}
-			if err != nil {
-				log.Printf("Error reading from ffmpeg stdout: %v", err)
-				break readLoop
+
+			if fadeNext == nil && crossfade > 0 && t.song.Duration > 0 {
+				remaining := t.song.Duration - t.position
+				if remaining > 0 && remaining <= crossfade {
+					// The prefetch is normally ready long before this; if
+					// not, the fade starts late and is shorter.
+					if fadeNext = state.prefetchedTrack(); fadeNext != nil {
+						fadeLen = remaining
+						log.Printf("Crossfading into %s over %s", fadeNext.song.Title, formatDuration(fadeLen))
+					}
+				}
+			}
+
+			fadeDone := false
+			if fadeNext != nil {
+				fadePos += frameDuration
+				progress := min(1, float64(fadePos)/float64(fadeLen))
+				mixPCM(pcm, fadeNext.readFrame(), progress)
+				fadeDone = progress >= 1
 			}
 
+			// Ramp volume changes across the frame to avoid clicks
+			scalePCM(pcm, gain, targetGain)
+			gain = targetGain
+
 			// Encode to opus exactly as original
 			opusData := make([]byte, maxBytes)
 			n, err := encoder.Encode(pcm, opusData)
@@ -777,9 +1470,63 @@ readLoop:
 				break readLoop
 			}
 
-			// Send opus data
-			vc.OpusSend <- opusData[:n]
+			// Send opus data, giving up on a connection that has gone
+			// away. The frame is lost, so resume from before it.
+			select {
+			case vc.OpusSend <- opusData[:n]:
+			case <-stop:
+				return streamStopped
+			case <-time.After(voiceStallTimeout):
+				return streamStalled
+			}
+
+			state.mu.Lock()
+			state.position = t.position
+			state.mu.Unlock()
+
+			if d := t.song.Duration; d > 0 && d-t.position <= prefetchLead {
+				state.prefetchNext(config)
+			}
+
+			if fadeDone {
+				// The rest of this song would be silent.
+				break readLoop
+			}
+		}
+	}
+
+	return streamEnded
+}
+
+// scalePCM multiplies interleaved stereo samples by a gain that moves linearly
+// from "from" to "to" across the frame, clipping to the int16 range.
+func scalePCM(pcm []int16, from, to float64) {
+	if from == 1 && to == 1 {
+		return
+	}
+
+	frames := len(pcm) / 2
+	for i := range frames {
+		gain := from + (to-from)*float64(i)/float64(frames)
+		for ch := range 2 {
+			v := float64(pcm[i*2+ch]) * gain
+			pcm[i*2+ch] = int16(max(math.MinInt16, min(math.MaxInt16, v)))
+		}
+	}
+}
+
+// mixPCM crossfades next into cur in place using an equal-power curve, where
+// progress runs from 0 (only cur) to 1 (only next). A nil next is silence.
+func mixPCM(cur, next []int16, progress float64) {
+	curGain := math.Cos(progress * math.Pi / 2)
+	nextGain := math.Sin(progress * math.Pi / 2)
+
+	for i := range cur {
+		v := float64(cur[i]) * curGain
+		if i < len(next) {
+			v += float64(next[i]) * nextGain
 		}
+		cur[i] = int16(max(math.MinInt16, min(math.MaxInt16, v)))
 	}
 }
 
@@ -852,33 +1599,50 @@ func getVideoInfos(query string, isPlaylist bool) ([]*VideoInfo, error) {
 		scanner := bufio.NewScanner(strings.NewReader(string(output)))
 		for scanner.Scan() {
 			var data struct {
-				ID       string  `json:"id"`
-				Title    string  `json:"title"`
-				Duration float64 `json:"duration"`
+				ID         string  `json:"id"`
+				Title      string  `json:"title"`
+				Duration   float64 `json:"duration"`
+				Uploader   string  `json:"uploader"`
+				Channel    string  `json:"channel"`
+				Thumbnails []struct {
+					URL string `json:"url"`
+				} `json:"thumbnails"`
 			}
 			if err := json.Unmarshal(scanner.Bytes(), &data); err != nil {
 				log.Printf("Skipping unparsable playlist item: %v", err)
 				continue
 			}
-			infos = append(infos, &VideoInfo{
+			info := &VideoInfo{
 				URL:      "https://www.youtube.com/watch?v=" + data.ID,
 				Title:    data.Title,
 				Duration: time.Duration(data.Duration * float64(time.Second)),
-			})
+				Uploader: cmp.Or(data.Uploader, data.Channel),
+			}
+			// Flat playlist entries list thumbnails from smallest to largest.
+			if n := len(data.Thumbnails); n > 0 {
+				info.Thumbnail = data.Thumbnails[n-1].URL
+			}
+			infos = append(infos, info)
 		}
 	} else {
 		var data struct {
-			URL      string  `json:"webpage_url"`
-			Title    string  `json:"title"`
-			Duration float64 `json:"duration"`
+			URL       string  `json:"webpage_url"`
+			Title     string  `json:"title"`
+			Duration  float64 `json:"duration"`
+			Thumbnail string  `json:"thumbnail"`
+			Artist    string  `json:"artist"`
+			Uploader  string  `json:"uploader"`
+			Channel   string  `json:"channel"`
 		}
 		if err := json.Unmarshal(output, &data); err != nil {
 			return nil, fmt.Errorf("failed to parse video info: %w", err)
 		}
 		infos = append(infos, &VideoInfo{
-			URL:      data.URL,
-			Title:    data.Title,
-			Duration: time.Duration(data.Duration * float64(time.Second)),
+			URL:       data.URL,
+			Title:     data.Title,
+			Duration:  time.Duration(data.Duration * float64(time.Second)),
+			Thumbnail: data.Thumbnail,
+			Uploader:  cmp.Or(data.Artist, data.Uploader, data.Channel),
 		})
 	}
 
@@ -927,13 +1691,94 @@ func getUserVoiceChannel(s *discordgo.Session, guildID, userID string) string {
 	return ""
 }
 
-func formatNowPlaying(song *Song, elapsed time.Duration) string {
-	return fmt.Sprintf("Now playing: [%s](%s)\n`%s / %s`",
-		song.Title,
-		song.URL,
-		formatDuration(elapsed),
-		formatDuration(song.Duration),
-	)
+// buildQueuePage renders one page of the queue as an embed along with the
+// navigation buttons. The page is clamped to the valid range so stale buttons
+// keep working after the queue shrinks.
+func buildQueuePage(songs []*Song, page int) (*discordgo.MessageEmbed, []discordgo.MessageComponent) {
+	pages := (len(songs) + queuePageSize - 1) / queuePageSize
+	page = max(0, min(page, pages-1))
+
+	var total time.Duration
+	for _, song := range songs {
+		total += song.Duration
+	}
+
+	start := page * queuePageSize
+	end := min(start+queuePageSize, len(songs))
+
+	var description strings.Builder
+	for idx, song := range songs[start:end] {
+		fmt.Fprintf(&description, "%d. [%s](%s) `%s`",
+			start+idx+1,
+			song.Title,
+			song.URL,
+			formatDuration(song.Duration),
+		)
+		if song.RequesterName != "" {
+			fmt.Fprintf(&description, " • %s", song.RequesterName)
+		}
+		description.WriteString("\n")
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       fmt.Sprintf("Queue (%d songs)", len(songs)),
+		Description: description.String(),
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Page %d/%d • Total remaining: %s", page+1, pages, formatDuration(total)),
+		},
+	}
+
+	components := []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Emoji: &discordgo.ComponentEmoji{
+						Name: "◀️",
+					},
+					Style:    discordgo.SecondaryButton,
+					CustomID: fmt.Sprintf("%s%d", queuePagePrefix, page-1),
+					Disabled: page == 0,
+				},
+				discordgo.Button{
+					Emoji: &discordgo.ComponentEmoji{
+						Name: "▶️",
+					},
+					Style:    discordgo.SecondaryButton,
+					CustomID: fmt.Sprintf("%s%d", queuePagePrefix, page+1),
+					Disabled: page >= pages-1,
+				},
+			},
+		},
+	}
+
+	return embed, components
+}
+
+// parseTimestamp parses a position given as seconds, mm:ss or hh:mm:ss.
+func parseTimestamp(value string) (time.Duration, error) {
+	parts := strings.Split(strings.TrimSpace(value), ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp %q, use mm:ss", value)
+	}
+
+	const maxSeconds = int64(time.Duration(math.MaxInt64) / time.Second)
+
+	var seconds int64
+	for idx, part := range parts {
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid timestamp %q, use mm:ss", value)
+		}
+		// Only the leading field may run past 59, as in 90:00.
+		if idx > 0 && n >= 60 {
+			return 0, fmt.Errorf("invalid timestamp %q, minutes and seconds must be below 60", value)
+		}
+		if seconds > (maxSeconds-n)/60 {
+			return 0, fmt.Errorf("timestamp %q is too long", value)
+		}
+		seconds = seconds*60 + n
+	}
+	return time.Duration(seconds) * time.Second, nil
 }
 
 func formatDuration(d time.Duration) string {
@@ -949,14 +1794,11 @@ func (gs *GuildState) stopPlayback(s *discordgo.Session) {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
 
-	if gs.done != nil {
-		close(gs.done)
-		gs.done = nil
-	}
-
-	for !gs.queue.IsEmpty() {
-		gs.queue.Get()
-	}
+	gs.queue.Clear()
+	gs.cancelPrefetch()
+	gs.endPlayback()
+	gs.paused = false
+	gs.autoPaused = false
 
 	if gs.process != nil {
 		gs.process.Kill()
@@ -967,6 +1809,7 @@ func (gs *GuildState) stopPlayback(s *discordgo.Session) {
 		newContent := "Playback stopped."
 		s.ChannelMessageEditComplex(&discordgo.MessageEdit{
 			Content:    &newContent,
+			Embeds:     &[]*discordgo.MessageEmbed{},
 			Components: &[]discordgo.MessageComponent{},
 			ID:         gs.nowPlaying.ID,
 			Channel:    gs.nowPlaying.ChannelID,
@@ -975,6 +1818,245 @@ func (gs *GuildState) stopPlayback(s *discordgo.Session) {
 	}
 }
 
+// status captures the playback state shown on the now-playing message.
+func (gs *GuildState) status() playerStatus {
+	gs.mu.Lock()
+	paused, loop, volume := gs.paused, gs.loopMode, gs.volume
+	var preset string
+	if gs.preset != nil {
+		preset = gs.preset.Name
+	}
+	votes, needed := len(gs.skipVotes), gs.votesNeeded
+	gs.mu.Unlock()
+
+	queued := gs.queue.Len()
+	return playerStatus{
+		Paused:     paused,
+		CanSkip:    queued > 0 || loop == LoopQueue,
+		CanShuffle: queued > 1,
+		Loop:       loop,
+		Volume:     volume,
+		Preset:     preset,
+
+		SkipVotes:       votes,
+		SkipVotesNeeded: needed,
+	}
+}
+
+// controls returns the now-playing buttons for the current playback state.
+func (gs *GuildState) controls() []discordgo.MessageComponent {
+	return musicButtons(gs.status())
+}
+
+// takeRequeued reports whether /skipto already put the song that just ended
+// back in the queue, and clears the flag.
+func (gs *GuildState) takeRequeued() bool {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	requeued := gs.requeued
+	gs.requeued = false
+	return requeued
+}
+
+func (gs *GuildState) getLoopMode() LoopMode {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	return gs.loopMode
+}
+
+// upNext returns the song that will play once the current one ends, taking
+// the loop mode into account. It must be called with the mutex held.
+func (gs *GuildState) upNext() *Song {
+	if gs.loopMode == LoopTrack && gs.current != nil {
+		return gs.current
+	}
+	if next := gs.queue.Peek(); next != nil {
+		return next
+	}
+	if gs.loopMode == LoopQueue {
+		return gs.current
+	}
+	return nil
+}
+
+// prefetchNext starts resolving and buffering the upcoming song unless it is
+// already being prefetched.
+func (gs *GuildState) prefetchNext(config *Config) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	if gs.prefetched != nil {
+		return
+	}
+	next := gs.upNext()
+	if next == nil {
+		return
+	}
+
+	gs.prefetched = &prefetch{
+		song:    next,
+		effects: gs.effects(),
+		ready:   make(chan struct{}),
+	}
+	go gs.prefetched.resolve(config)
+}
+
+// prefetchedTrack returns the prefetched track if it is already buffering,
+// without waiting for an in-flight resolve. The track stays prefetched so the
+// next song still picks it up.
+func (gs *GuildState) prefetchedTrack() *track {
+	gs.mu.Lock()
+	p := gs.prefetched
+	gs.mu.Unlock()
+
+	if p == nil {
+		return nil
+	}
+	select {
+	case <-p.ready:
+	default:
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.track
+}
+
+// takePrefetched returns the buffered track for song if it was prefetched.
+// Any prefetch for a different song is cancelled.
+func (gs *GuildState) takePrefetched(song *Song) *track {
+	gs.mu.Lock()
+	p := gs.prefetched
+	gs.prefetched = nil
+	gs.mu.Unlock()
+
+	if p == nil {
+		return nil
+	}
+	if p.song != song {
+		p.cancel()
+		return nil
+	}
+	return p.take()
+}
+
+// refreshPrefetch cancels the prefetched song if a queue or loop mode change
+// means it is no longer the one that plays next. The next song is prefetched
+// again while the current one keeps streaming.
+func (gs *GuildState) refreshPrefetch() {
+	gs.mu.Lock()
+	p := gs.prefetched
+	if p == nil || p.song == gs.upNext() {
+		gs.mu.Unlock()
+		return
+	}
+	gs.prefetched = nil
+	gs.mu.Unlock()
+
+	p.cancel()
+}
+
+// cancelPrefetch drops any prefetched song. It must be called with the mutex
+// held.
+func (gs *GuildState) cancelPrefetch() {
+	if gs.prefetched != nil {
+		gs.prefetched.cancel()
+		gs.prefetched = nil
+	}
+}
+
+// effects returns the filters applied on top of the configured chain. It must
+// be called with the mutex held.
+func (gs *GuildState) effects() audioEffects {
+	return newAudioEffects(gs.preset, gs.eq)
+}
+
+// setEffects applies a change to the guild's effects and restarts the current
+// song at its position so the change is heard right away. The prefetched song
+// was started with the old effects, so it is dropped.
+func (gs *GuildState) setEffects(change func()) {
+	gs.mu.Lock()
+	change()
+	gs.cancelPrefetch()
+	position, playing := gs.position, gs.current != nil
+	gs.mu.Unlock()
+
+	if playing {
+		gs.seek(position)
+	}
+}
+
+// seek restarts the current song at target. A pending seek that has not been
+// picked up yet is replaced.
+func (gs *GuildState) seek(target time.Duration) error {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	if gs.seekChan == nil || gs.current == nil {
+		return fmt.Errorf("nothing is playing")
+	}
+	if target < 0 {
+		target = 0
+	}
+	if d := gs.current.Duration; d > 0 && target >= d {
+		return fmt.Errorf("%s is past the end of the song (%s)", formatDuration(target), formatDuration(d))
+	}
+
+	select {
+	case <-gs.seekChan:
+	default:
+	}
+	gs.seekChan <- target
+	return nil
+}
+
+// skip stops the current song so that playback moves on to the next one. It
+// reports false when nothing is playing.
+func (gs *GuildState) skip() bool {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	if gs.skipChan == nil {
+		return false
+	}
+
+	if gs.paused {
+		gs.paused = false
+		gs.autoPaused = false
+		if gs.voice != nil {
+			gs.voice.Speaking(true)
+		}
+	}
+
+	// Non-blocking send to the skip channel.
+	select {
+	case gs.skipChan <- true:
+	default:
+		// If the channel is full, a skip is already pending.
+	}
+	return true
+}
+
+// endPlayback makes playSound finish the current song without moving on to
+// the next one. Killing ffmpeg is not enough, as the track still has frames
+// buffered. It must be called with the mutex held.
+func (gs *GuildState) endPlayback() {
+	if gs.stopChan != nil && !isClosed(gs.stopChan) {
+		close(gs.stopChan)
+	}
+}
+
+// isClosed reports whether ch has been closed.
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
 func (gs *GuildState) cleanup() {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
@@ -982,20 +2064,28 @@ func (gs *GuildState) cleanup() {
 	if gs.process != nil {
 		gs.process.Kill()
 	}
+	// The song may be paused, with streamAudio waiting for a resume that
+	// will never come.
+	gs.endPlayback()
+	gs.cancelPrefetch()
 	if gs.voice != nil {
 		gs.voice.Disconnect()
+		gs.voice = nil
 	}
 	if gs.inactiveTimer != nil {
 		gs.inactiveTimer.Stop()
 	}
+	if gs.emptyTimer != nil {
+		gs.emptyTimer.Stop()
+	}
 }
 
-func (gs *GuildState) startInactivityTimer(callback func()) {
+func (gs *GuildState) startInactivityTimer(timeout time.Duration, callback func()) {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
 
 	gs.cancelInactivityTimer()
-	gs.inactiveTimer = time.AfterFunc(30*time.Second, callback)
+	gs.inactiveTimer = time.AfterFunc(timeout, callback)
 }
 
 func (gs *GuildState) cancelInactivityTimer() {
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		err   bool
+	}{
+		{"0", 0, false},
+		{"90", 90 * time.Second, false},
+		{"1:30", 90 * time.Second, false},
+		{" 01:05 ", 65 * time.Second, false},
+		{"90:00", 90 * time.Minute, false},
+		{"1:02:03", time.Hour + 2*time.Minute + 3*time.Second, false},
+		{"2562047:47:16", 2562047*time.Hour + 47*time.Minute + 16*time.Second, false},
+
+		{"", 0, true},
+		{"abc", 0, true},
+		{"1:-5", 0, true},
+		{"1::5", 0, true},
+		{"1:2:3:4", 0, true},
+		{"1:60", 0, true},
+		{"1:60:00", 0, true},
+		{"1:00:60", 0, true},
+		{"2562047:47:17", 0, true},
+		{"99999999999:00", 0, true},
+		{"9223372036854775807", 0, true},
+		{"99999999999999999999", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseTimestamp(tt.value)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseTimestamp(%q) = %v, want an error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseTimestamp(%q) returned %v", tt.value, err)
+		} else if got != tt.want {
+			t.Errorf("parseTimestamp(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
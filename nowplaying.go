@@ -0,0 +1,337 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// nowPlayingEditGap is the minimum time between two now-playing edits
+	// across all guilds, which keeps the bot well below Discord's global
+	// rate limit however many guilds it plays in.
+	nowPlayingEditGap = 250 * time.Millisecond
+
+	// nowPlayingMinAge is the minimum time between two edits of the same
+	// message, so bursts of changes are shown in a single edit.
+	nowPlayingMinAge = 2 * time.Second
+
+	// nowPlayingIdle is how long the updater sleeps when nothing is playing;
+	// markDirty and track wake it early.
+	nowPlayingIdle = time.Minute
+
+	// progressBarWidth is the number of segments in the now-playing
+	// progress bar.
+	progressBarWidth = 16
+
+	// upNextCount is how many queued songs the now-playing embed lists.
+	upNextCount = 3
+)
+
+// buildNowPlaying renders the now-playing embed for song at elapsed, with
+// the player status and the songs queued after it.
+func buildNowPlaying(song *Song, elapsed time.Duration, status playerStatus, queue []*Song) *discordgo.MessageEmbed {
+	heading := "Now playing"
+	if status.Paused {
+		heading = "Paused"
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Author:      &discordgo.MessageEmbedAuthor{Name: heading},
+		Title:       song.Title,
+		URL:         song.URL,
+		Description: progressBar(elapsed, song.Duration),
+	}
+
+	if song.Thumbnail != "" {
+		embed.Thumbnail = &discordgo.MessageEmbedThumbnail{URL: song.Thumbnail}
+	}
+
+	if song.Uploader != "" {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Artist", Value: song.Uploader, Inline: true})
+	}
+
+	embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Status", Value: formatStatus(status), Inline: true})
+
+	embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Up next", Value: formatUpNext(status, queue)})
+
+	if song.RequesterName != "" {
+		embed.Footer = &discordgo.MessageEmbedFooter{
+			Text:    "Requested by " + song.RequesterName,
+			IconURL: song.RequesterAvatar,
+		}
+	}
+
+	return embed
+}
+
+// progressBar draws elapsed against total as a bar with timestamps on
+// either side. Streams without a known length only show the elapsed time.
+func progressBar(elapsed, total time.Duration) string {
+	if total <= 0 {
+		return fmt.Sprintf("`%s`", formatDuration(elapsed))
+	}
+
+	filled := int(float64(progressBarWidth-1) * min(1, float64(elapsed)/float64(total)))
+	return fmt.Sprintf("`%s` %s🔘%s `%s`",
+		formatDuration(elapsed),
+		strings.Repeat("▬", filled),
+		strings.Repeat("▬", progressBarWidth-1-filled),
+		formatDuration(total),
+	)
+}
+
+// formatStatus summarizes the volume, effects, loop mode and skip votes.
+func formatStatus(status playerStatus) string {
+	parts := []string{fmt.Sprintf("🔊 %d%%", status.Volume)}
+
+	if status.Preset != "" {
+		parts = append(parts, fmt.Sprintf("🎛️ %s", status.Preset))
+	}
+
+	switch status.Loop {
+	case LoopTrack:
+		parts = append(parts, "🔂 Repeating track")
+	case LoopQueue:
+		parts = append(parts, "🔁 Repeating queue")
+	}
+
+	if status.SkipVotes > 0 {
+		parts = append(parts, fmt.Sprintf("⏭️ %d/%d votes to skip", status.SkipVotes, status.SkipVotesNeeded))
+	}
+	return strings.Join(parts, "\n")
+}
+
+// formatUpNext lists the first few songs that will play after the current
+// one.
+func formatUpNext(status playerStatus, queue []*Song) string {
+	if status.Loop == LoopTrack {
+		return "This track again"
+	}
+	if len(queue) == 0 {
+		if status.Loop == LoopQueue {
+			return "This track again"
+		}
+		return "Nothing queued"
+	}
+
+	var lines []string
+	for idx, next := range queue[:min(upNextCount, len(queue))] {
+		line := fmt.Sprintf("%d. [%s](%s) `%s`", idx+1, next.Title, next.URL, formatDuration(next.Duration))
+		if next.RequesterName != "" {
+			line += " • " + next.RequesterName
+		}
+		lines = append(lines, line)
+	}
+	if more := len(queue) - upNextCount; more > 0 {
+		lines = append(lines, fmt.Sprintf("…and %d more", more))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// nowPlayingSchedule is the update state of one guild's now-playing message.
+type nowPlayingSchedule struct {
+	dirty     bool      // Something changed that the message does not show yet
+	lastEdit  time.Time // When the message was last edited
+	notBefore time.Time // Rate limit backoff from a 429 response
+}
+
+// nowPlayingUpdater decides when each guild's now-playing message is edited.
+// Changes are shown soon after they happen, progress otherwise only every
+// NOW_PLAYING_INTERVAL seconds, and all edits go through one goroutine so
+// they can be paced globally.
+type nowPlayingUpdater struct {
+	guilds map[string]*nowPlayingSchedule
+	wake   chan struct{}
+	mu     sync.Mutex
+}
+
+func newNowPlayingUpdater() *nowPlayingUpdater {
+	return &nowPlayingUpdater{
+		guilds: make(map[string]*nowPlayingSchedule),
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// track starts updating a guild's now-playing message, showing the current
+// song as soon as possible.
+func (u *nowPlayingUpdater) track(guildID string) {
+	u.mu.Lock()
+	if _, ok := u.guilds[guildID]; !ok {
+		u.guilds[guildID] = &nowPlayingSchedule{}
+	}
+	u.guilds[guildID].dirty = true
+	u.mu.Unlock()
+	u.signal()
+}
+
+// markDirty asks for a guild's now-playing message to be updated soon. It
+// does nothing for guilds that are not playing.
+func (u *nowPlayingUpdater) markDirty(guildID string) {
+	u.mu.Lock()
+	schedule, ok := u.guilds[guildID]
+	if ok {
+		schedule.dirty = true
+	}
+	u.mu.Unlock()
+	if ok {
+		u.signal()
+	}
+}
+
+func (u *nowPlayingUpdater) signal() {
+	select {
+	case u.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the guild whose message is due soonest and how long until it
+// is due. Changes are due nowPlayingMinAge after the last edit, progress
+// updates interval after it.
+func (u *nowPlayingUpdater) next(now time.Time, interval time.Duration) (string, time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var guildID string
+	var due time.Time
+	for id, schedule := range u.guilds {
+		at := schedule.lastEdit.Add(interval)
+		if schedule.dirty {
+			at = schedule.lastEdit.Add(nowPlayingMinAge)
+		}
+		if at.Before(schedule.notBefore) {
+			at = schedule.notBefore
+		}
+		if guildID == "" || at.Before(due) {
+			guildID, due = id, at
+		}
+	}
+
+	if guildID == "" {
+		return "", nowPlayingIdle
+	}
+	return guildID, max(0, due.Sub(now))
+}
+
+// take marks a guild's message as edited now and reports whether it had
+// unshown changes.
+func (u *nowPlayingUpdater) take(guildID string, now time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	schedule, ok := u.guilds[guildID]
+	if !ok {
+		return false
+	}
+	dirty := schedule.dirty
+	schedule.dirty = false
+	schedule.lastEdit = now
+	return dirty
+}
+
+// backoff holds off editing a guild's message until retryAfter has passed
+// and keeps the pending change so it is retried then.
+func (u *nowPlayingUpdater) backoff(guildID string, retryAfter time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if schedule, ok := u.guilds[guildID]; ok {
+		schedule.dirty = true
+		schedule.notBefore = time.Now().Add(retryAfter)
+	}
+}
+
+// forget stops updating a guild's message.
+func (u *nowPlayingUpdater) forget(guildID string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.guilds, guildID)
+}
+
+// runNowPlayingUpdates edits now-playing messages as they fall due, one at a
+// time and at most one every nowPlayingEditGap, until stop is closed.
+func (b *Bot) runNowPlayingUpdates(stop <-chan struct{}) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		interval := time.Duration(b.config().NowPlayingInterval) * time.Second
+		guildID, wait := b.nowPlaying.next(time.Now(), interval)
+
+		timer.Reset(wait)
+		select {
+		case <-stop:
+			return
+		case <-b.nowPlaying.wake:
+			// Something changed; work out what is due again.
+			timer.Stop()
+			continue
+		case <-timer.C:
+		}
+
+		if guildID == "" {
+			continue
+		}
+		b.editNowPlaying(guildID)
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(nowPlayingEditGap):
+		}
+	}
+}
+
+// editNowPlaying brings a guild's now-playing message up to date.
+func (b *Bot) editNowPlaying(guildID string) {
+	b.mu.RLock()
+	state, ok := b.guilds[guildID]
+	b.mu.RUnlock()
+	if !ok {
+		b.nowPlaying.forget(guildID)
+		return
+	}
+
+	state.mu.Lock()
+	msg := state.nowPlaying
+	song := state.current
+	elapsed := state.position
+	paused := state.paused
+	state.mu.Unlock()
+
+	if msg == nil {
+		b.nowPlaying.forget(guildID)
+		return
+	}
+
+	dirty := b.nowPlaying.take(guildID, time.Now())
+	if song == nil || (paused && !dirty) {
+		// Between songs, or paused with nothing new to show.
+		return
+	}
+
+	embeds := []*discordgo.MessageEmbed{buildNowPlaying(song, elapsed, state.status(), state.queue.List())}
+	components := state.controls()
+
+	// Fail instead of sleeping on a 429 so other guilds are not held up.
+	_, err := b.session.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		Embeds:     &embeds,
+		Components: &components,
+		ID:         msg.ID,
+		Channel:    msg.ChannelID,
+	}, discordgo.WithRetryOnRatelimit(false))
+
+	var rateLimited *discordgo.RateLimitError
+	if errors.As(err, &rateLimited) {
+		log.Printf("Now playing message in guild %s rate limited, retrying in %v", guildID, rateLimited.RetryAfter)
+		b.nowPlaying.backoff(guildID, rateLimited.RetryAfter)
+	} else if err != nil {
+		log.Printf("Error editing now playing message: %v", err)
+	}
+}
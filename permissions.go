@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// permission is the access a member needs to use a command or button.
+type permission int
+
+const (
+	permAnyone   permission = iota // No restriction
+	permListener                   // Must be in the bot's voice channel while it is connected
+	permDJ                         // Listener who also has the DJ role, if the guild set one
+)
+
+// commandPermissions maps slash commands to the access they need. Commands
+// that are not listed are open to anyone; /settings, /247 and /reload-config
+// check their own, stricter requirements.
+var commandPermissions = map[string]permission{
+	"play":   permListener,
+	"dj":     permListener,
+	"skip":   permListener, // Votes unless the member is the requester or a DJ
+	"remove": permListener, // Only their own songs unless the member is a DJ
+
+	"stop":      permDJ,
+	"pause":     permDJ,
+	"move":      permDJ,
+	"swap":      permDJ,
+	"clear":     permDJ,
+	"skipto":    permDJ,
+	"loop":      permDJ,
+	"shuffle":   permDJ,
+	"seek":      permDJ,
+	"volume":    permDJ,
+	"crossfade": permDJ,
+	"filter":    permDJ,
+	"eq":        permDJ,
+}
+
+// componentPermissions maps the now-playing buttons to the access they need,
+// matching the equivalent commands.
+var componentPermissions = map[string]permission{
+	"music_skip":    permListener,
+	"music_pause":   permDJ,
+	"music_stop":    permDJ,
+	"music_loop":    permDJ,
+	"music_shuffle": permDJ,
+	"music_rewind":  permDJ,
+	"music_forward": permDJ,
+}
+
+// isDJ reports whether the member invoking an interaction has the guild's DJ
+// role or the Manage Server permission.
+func isDJ(i *discordgo.InteractionCreate, settings GuildSettings) bool {
+	if canManageGuild(i) {
+		return true
+	}
+	return settings.DJRoleID != "" && i.Member != nil && slices.Contains(i.Member.Roles, settings.DJRoleID)
+}
+
+// hasDJAccess reports whether the member may use DJ-only controls. Without a
+// DJ role configured, everyone may.
+func hasDJAccess(i *discordgo.InteractionCreate, settings GuildSettings) bool {
+	return settings.DJRoleID == "" || isDJ(i, settings)
+}
+
+// checkPermission returns the reason the member invoking i may not use
+// something that needs perm, or an empty string if they may.
+func (b *Bot) checkPermission(s *discordgo.Session, i *discordgo.InteractionCreate, perm permission) string {
+	if perm == permAnyone {
+		return ""
+	}
+	if i.Member == nil {
+		return "This can only be used in a server"
+	}
+
+	settings := b.settings.Get(i.GuildID)
+	if perm == permDJ && !hasDJAccess(i, settings) {
+		return fmt.Sprintf("Only members with the <@&%s> role can do that", settings.DJRoleID)
+	}
+
+	b.mu.RLock()
+	state, ok := b.guilds[i.GuildID]
+	b.mu.RUnlock()
+	if !ok {
+		return ""
+	}
+
+	state.mu.Lock()
+	var channelID string
+	if state.voice != nil {
+		channelID = state.voice.ChannelID
+	}
+	state.mu.Unlock()
+
+	if channelID != "" && getUserVoiceChannel(s, i.GuildID, i.Member.User.ID) != channelID {
+		return fmt.Sprintf("You must be in <#%s> to control playback", channelID)
+	}
+	return ""
+}
@@ -1,19 +1,74 @@
 package main
 
 import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sort"
 	"sync"
 	"time"
 )
 
 type Song struct {
-	URL       string
-	ChannelID string
-	Duration  time.Duration
-	Title     string
+	URL             string
+	ChannelID       string
+	Duration        time.Duration
+	Title           string
+	Uploader        string // Artist or channel that published the song
+	Thumbnail       string // URL of the cover art or video thumbnail
+	RequesterID     string // User who queued the song
+	RequesterName   string // Their display name in the guild at the time
+	RequesterAvatar string // URL of their avatar
 }
 
+// LoopMode controls what happens to a song once it finishes playing.
+type LoopMode int
+
+const (
+	LoopOff   LoopMode = iota // Discard finished songs
+	LoopTrack                 // Replay the current song until skipped
+	LoopQueue                 // Re-append finished songs to the queue
+)
+
+// ParseLoopMode converts the name used by the /loop command into a LoopMode.
+func ParseLoopMode(name string) (LoopMode, error) {
+	switch name {
+	case "off":
+		return LoopOff, nil
+	case "track":
+		return LoopTrack, nil
+	case "queue":
+		return LoopQueue, nil
+	}
+	return LoopOff, fmt.Errorf("unknown loop mode %q", name)
+}
+
+func (m LoopMode) String() string {
+	switch m {
+	case LoopTrack:
+		return "track"
+	case LoopQueue:
+		return "queue"
+	}
+	return "off"
+}
+
+// Next returns the mode that follows m when cycling through modes.
+func (m LoopMode) Next() LoopMode {
+	return (m + 1) % 3
+}
+
+func (m LoopMode) Emoji() string {
+	if m == LoopTrack {
+		return "🔂"
+	}
+	return "🔁"
+}
+
+// Queue holds the songs waiting to play, always in the order they will play.
 type Queue struct {
 	songs []*Song
+	fair  bool // Interleave songs by requester
 	mut   sync.Mutex
 }
 
@@ -23,12 +78,83 @@ func NewQueue() *Queue {
 	}
 }
 
+// Add queues song at the end, or in fair mode after every song in the same
+// or an earlier round.
 func (q *Queue) Add(song *Song) {
 	q.mut.Lock()
 	defer q.mut.Unlock()
+	if q.fair {
+		q.songs = slices.Insert(q.songs, q.fairPosition(song), song)
+		return
+	}
 	q.songs = append(q.songs, song)
 }
 
+// SetFair turns fair mode on or off. In fair mode songs play round-robin
+// between requesters, each requester's songs keeping their own order, so one
+// long playlist cannot starve everyone else. Turning it on reorders the songs
+// already queued; turning it off keeps the current order.
+func (q *Queue) SetFair(fair bool) {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	if fair && !q.fair {
+		q.interleave()
+	}
+	q.fair = fair
+}
+
+// IsFair reports whether fair mode is on.
+func (q *Queue) IsFair() bool {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	return q.fair
+}
+
+// fairPosition returns the index song should be inserted at in fair mode: in
+// front of the first song from a later round. A song's round is how many of
+// its requester's songs are queued up to and including it. It must be called
+// with the mutex held.
+func (q *Queue) fairPosition(song *Song) int {
+	round := 1
+	for _, queued := range q.songs {
+		if queued.RequesterID == song.RequesterID {
+			round++
+		}
+	}
+
+	counts := make(map[string]int)
+	for idx, queued := range q.songs {
+		counts[queued.RequesterID]++
+		if counts[queued.RequesterID] > round {
+			return idx
+		}
+	}
+	return len(q.songs)
+}
+
+// interleave reorders the songs into rounds, keeping the relative order of
+// songs within a round. It must be called with the mutex held.
+func (q *Queue) interleave() {
+	type rankedSong struct {
+		song  *Song
+		round int
+	}
+
+	ranked := make([]rankedSong, len(q.songs))
+	counts := make(map[string]int)
+	for idx, song := range q.songs {
+		counts[song.RequesterID]++
+		ranked[idx] = rankedSong{song, counts[song.RequesterID]}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].round < ranked[j].round
+	})
+
+	for idx := range ranked {
+		q.songs[idx] = ranked[idx].song
+	}
+}
+
 func (q *Queue) Get() *Song {
 	q.mut.Lock()
 	defer q.mut.Unlock()
@@ -40,12 +166,23 @@ func (q *Queue) Get() *Song {
 	return song
 }
 
+// Peek returns the next song without removing it.
+func (q *Queue) Peek() *Song {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	if len(q.songs) == 0 {
+		return nil
+	}
+	return q.songs[0]
+}
+
 func (q *Queue) IsEmpty() bool {
 	q.mut.Lock()
 	defer q.mut.Unlock()
 	return len(q.songs) == 0
 }
 
+// List returns the queued songs in the order they will play.
 func (q *Queue) List() []*Song {
 	q.mut.Lock()
 	defer q.mut.Unlock()
@@ -54,3 +191,131 @@ func (q *Queue) List() []*Song {
 	copy(songsCopy, q.songs)
 	return songsCopy
 }
+
+// Remove deletes the song at index and returns it.
+func (q *Queue) Remove(index int) (*Song, error) {
+	return q.RemoveIf(index, nil)
+}
+
+// RemoveIf deletes the song at index and returns it, unless allow returns an
+// error for that song. A nil allow permits any song.
+func (q *Queue) RemoveIf(index int, allow func(*Song) error) (*Song, error) {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	if err := q.checkIndex(index); err != nil {
+		return nil, err
+	}
+	song := q.songs[index]
+	if allow != nil {
+		if err := allow(song); err != nil {
+			return nil, err
+		}
+	}
+	q.songs = append(q.songs[:index], q.songs[index+1:]...)
+	return song, nil
+}
+
+// RemoveWhere deletes every song for which match returns true and returns
+// them in queue order.
+func (q *Queue) RemoveWhere(match func(*Song) bool) []*Song {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	var removed []*Song
+	kept := q.songs[:0]
+	for _, song := range q.songs {
+		if match(song) {
+			removed = append(removed, song)
+		} else {
+			kept = append(kept, song)
+		}
+	}
+	clear(q.songs[len(kept):])
+	q.songs = kept
+	return removed
+}
+
+// Move moves the song at from so that it ends up at index to, shifting the
+// songs in between.
+func (q *Queue) Move(from, to int) (*Song, error) {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	if err := q.checkIndex(from); err != nil {
+		return nil, err
+	}
+	if err := q.checkIndex(to); err != nil {
+		return nil, err
+	}
+	song := q.songs[from]
+	q.songs = append(q.songs[:from], q.songs[from+1:]...)
+	q.songs = append(q.songs[:to], append([]*Song{song}, q.songs[to:]...)...)
+	return song, nil
+}
+
+// Swap exchanges the songs at indexes i and j.
+func (q *Queue) Swap(i, j int) error {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	if err := q.checkIndex(i); err != nil {
+		return err
+	}
+	if err := q.checkIndex(j); err != nil {
+		return err
+	}
+	q.songs[i], q.songs[j] = q.songs[j], q.songs[i]
+	return nil
+}
+
+// Shuffle randomizes the order of the pending songs.
+func (q *Queue) Shuffle() {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	rand.Shuffle(len(q.songs), func(i, j int) {
+		q.songs[i], q.songs[j] = q.songs[j], q.songs[i]
+	})
+	if q.fair {
+		// Shuffle each requester's songs but keep taking turns.
+		q.interleave()
+	}
+}
+
+// Clear removes every song and returns how many were dropped.
+func (q *Queue) Clear() int {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	n := len(q.songs)
+	q.songs = make([]*Song, 0)
+	return n
+}
+
+// SkipTo makes the song at index the next one to play and drops the songs
+// before it. When looping the queue, pass the song that is playing as
+// current: it and the songs skipped over then go to the end instead, in the
+// order they would have played.
+func (q *Queue) SkipTo(index int, current *Song) (*Song, error) {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	if err := q.checkIndex(index); err != nil {
+		return nil, err
+	}
+	if current != nil {
+		q.songs = slices.Concat(q.songs[index:], []*Song{current}, q.songs[:index])
+	} else {
+		q.songs = q.songs[index:]
+	}
+	return q.songs[0], nil
+}
+
+func (q *Queue) Len() int {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	return len(q.songs)
+}
+
+// checkIndex must be called with the mutex held.
+func (q *Queue) checkIndex(index int) error {
+	if index < 0 || index >= len(q.songs) {
+		return fmt.Errorf("position %d is out of range (queue has %d songs)", index+1, len(q.songs))
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testSongs makes a song for each name, such as "A1". The letter is the
+// requester and the whole name is the title.
+func testSongs(names string) []*Song {
+	var songs []*Song
+	for _, name := range strings.Fields(names) {
+		songs = append(songs, &Song{Title: name, RequesterID: name[:1]})
+	}
+	return songs
+}
+
+// titles lists the titles of songs, separated by spaces.
+func titles(songs []*Song) string {
+	names := make([]string, len(songs))
+	for i, song := range songs {
+		names[i] = song.Title
+	}
+	return strings.Join(names, " ")
+}
+
+func TestQueueFairAdd(t *testing.T) {
+	tests := []struct {
+		name  string
+		added string
+		want  string
+	}{
+		{"empty", "", ""},
+		{"one requester", "A1 A2 A3", "A1 A2 A3"},
+		{"takes turns", "A1 A2 A3 B1 C1 B2", "A1 B1 C1 A2 B2 A3"},
+		{"late requester goes to the next round", "A1 B1 A2 B2 C1", "A1 B1 C1 A2 B2"},
+		{"keeps each requester's order", "A1 A2 A3 A4 B1 B2", "A1 B1 A2 B2 A3 A4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewQueue()
+			q.SetFair(true)
+			for _, song := range testSongs(tt.added) {
+				q.Add(song)
+			}
+			if got := titles(q.List()); got != tt.want {
+				t.Errorf("queue = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueueSetFair(t *testing.T) {
+	tests := []struct {
+		name   string
+		queued string
+		want   string
+	}{
+		{"empty", "", ""},
+		{"one requester", "A1 A2 A3", "A1 A2 A3"},
+		{"interleaves", "A1 A2 A3 B1 B2 C1", "A1 B1 C1 A2 B2 A3"},
+		{"keeps order within a round", "B1 A1 A2 B2", "B1 A1 A2 B2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewQueue()
+			for _, song := range testSongs(tt.queued) {
+				q.Add(song)
+			}
+			q.SetFair(true)
+			if got := titles(q.List()); got != tt.want {
+				t.Errorf("queue = %q, want %q", got, tt.want)
+			}
+
+			// Turning fair mode off again keeps the order.
+			q.SetFair(false)
+			if got := titles(q.List()); got != tt.want {
+				t.Errorf("queue after turning fair mode off = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueueShuffleFair(t *testing.T) {
+	songs := testSongs("A1 A2 A3 A4 B1 B2 C1")
+
+	for range 50 {
+		q := NewQueue()
+		q.SetFair(true)
+		for _, song := range songs {
+			q.Add(song)
+		}
+		q.Shuffle()
+		got := q.List()
+
+		if !sameSongs(got, songs) {
+			t.Fatalf("shuffled queue %q does not hold the songs %q", titles(got), titles(songs))
+		}
+
+		// Every requester plays once per round until they run out.
+		counts := make(map[string]int)
+		lastRound := 1
+		for _, song := range got {
+			counts[song.RequesterID]++
+			round := counts[song.RequesterID]
+			if round < lastRound {
+				t.Fatalf("shuffled queue %q does not take turns", titles(got))
+			}
+			lastRound = round
+		}
+	}
+}
+
+// sameSongs reports whether a and b hold the same songs in any order.
+func sameSongs(a, b []*Song) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, song := range a {
+		if !slices.Contains(b, song) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestQueueSkipTo(t *testing.T) {
+	current := &Song{Title: "A0", RequesterID: "A"}
+
+	tests := []struct {
+		name    string
+		index   int
+		current *Song
+		want    string
+	}{
+		{"next song", 0, nil, "A1 A2 A3 A4"},
+		{"drops skipped songs", 2, nil, "A3 A4"},
+		{"last song", 3, nil, "A4"},
+		{"looping keeps the current song first", 0, current, "A1 A2 A3 A4 A0"},
+		{"looping keeps skipped songs in order", 2, current, "A3 A4 A0 A1 A2"},
+		{"looping to the last song", 3, current, "A4 A0 A1 A2 A3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewQueue()
+			for _, song := range testSongs("A1 A2 A3 A4") {
+				q.Add(song)
+			}
+
+			song, err := q.SkipTo(tt.index, tt.current)
+			if err != nil {
+				t.Fatalf("SkipTo(%d) returned %v", tt.index, err)
+			}
+			if got := titles(q.List()); got != tt.want {
+				t.Errorf("queue = %q, want %q", got, tt.want)
+			}
+			if song != q.Peek() {
+				t.Errorf("SkipTo(%d) returned %s, but %s plays next", tt.index, song.Title, q.Peek().Title)
+			}
+		})
+	}
+
+	for _, index := range []int{-1, 4} {
+		q := NewQueue()
+		for _, song := range testSongs("A1 A2 A3 A4") {
+			q.Add(song)
+		}
+		if _, err := q.SkipTo(index, current); err == nil {
+			t.Errorf("SkipTo(%d) on a queue of 4 did not return an error", index)
+		}
+		if got := titles(q.List()); got != "A1 A2 A3 A4" {
+			t.Errorf("queue after a failed SkipTo(%d) = %q, want it unchanged", index, got)
+		}
+	}
+}
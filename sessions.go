@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// sessionSaveInterval is how often playback state is saved while running, so
+// a crash loses at most this much progress.
+const sessionSaveInterval = 30 * time.Second
+
+// savedSession is the playback state of one guild as written to disk.
+type savedSession struct {
+	VoiceChannelID string        `json:"voice_channel_id"`
+	TextChannelID  string        `json:"text_channel_id"`
+	Current        *Song         `json:"current,omitempty"`
+	Position       time.Duration `json:"position"`
+	Paused         bool          `json:"paused"`
+	LoopMode       LoopMode      `json:"loop_mode"`
+	Queue          []*Song       `json:"queue"`
+}
+
+// SessionStore saves the playback state of every guild to a JSON file so it
+// can be resumed after a restart. An empty path disables it.
+type SessionStore struct {
+	path string
+}
+
+func NewSessionStore(path string) *SessionStore {
+	return &SessionStore{path: path}
+}
+
+// Load reads the saved sessions keyed by guild ID. A missing file yields no
+// sessions.
+func (s *SessionStore) Load() (map[string]savedSession, error) {
+	if s.path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading session file: %w", err)
+	}
+
+	var sessions map[string]savedSession
+	if err := json.Unmarshal(data, &sessions); err != nil {
+		return nil, fmt.Errorf("parsing session file: %w", err)
+	}
+	return sessions, nil
+}
+
+// Save replaces the saved sessions.
+func (s *SessionStore) Save(sessions map[string]savedSession) error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(sessions, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding sessions: %w", err)
+	}
+	return writeFileAtomic(s.path, data)
+}
+
+// snapshot captures the playback state of the guilds the bot is playing in.
+func (gs *GuildState) snapshot() (savedSession, bool) {
+	queue := gs.queue.List()
+
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	if gs.voice == nil || (gs.current == nil && len(queue) == 0) {
+		return savedSession{}, false
+	}
+
+	session := savedSession{
+		VoiceChannelID: gs.voice.ChannelID,
+		Current:        gs.current,
+		Position:       gs.position,
+		Paused:         gs.paused && !gs.autoPaused, // Re-checked on restore
+		LoopMode:       gs.loopMode,
+		Queue:          queue,
+	}
+	if gs.nowPlaying != nil {
+		session.TextChannelID = gs.nowPlaying.ChannelID
+	} else if gs.current != nil {
+		session.TextChannelID = gs.current.ChannelID
+	}
+	return session, true
+}
+
+// saveSessions writes the playback state of every guild to the session store.
+func (b *Bot) saveSessions() {
+	b.mu.RLock()
+	sessions := make(map[string]savedSession)
+	for guildID, state := range b.guilds {
+		if session, ok := state.snapshot(); ok {
+			sessions[guildID] = session
+		}
+	}
+	b.mu.RUnlock()
+
+	if err := b.sessions.Save(sessions); err != nil {
+		log.Printf("Error saving sessions: %v", err)
+	}
+}
+
+// saveSessionsPeriodically saves the playback state until stop is closed.
+func (b *Bot) saveSessionsPeriodically(stop <-chan struct{}) {
+	ticker := time.NewTicker(sessionSaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			b.saveSessions()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// restoreSessions rejoins the voice channels saved before the last shutdown
+// and resumes playback where it left off, then rejoins the remaining 24/7
+// channels.
+func (b *Bot) restoreSessions(s *discordgo.Session) {
+	sessions, err := b.sessions.Load()
+	if err != nil {
+		log.Printf("Error loading sessions: %v", err)
+		return
+	}
+
+	for guildID, session := range sessions {
+		log.Printf("Restoring session in guild %s", guildID)
+		state := b.getOrCreateGuildState(guildID)
+
+		notice, err := b.ensureVoiceConnection(s, guildID, session.VoiceChannelID, state)
+		if err != nil {
+			log.Printf("Error rejoining voice channel in guild %s: %v", guildID, err)
+			b.disconnectFromGuild(guildID)
+			continue
+		}
+
+		state.mu.Lock()
+		state.paused = session.Paused
+		state.loopMode = session.LoopMode
+		state.mu.Unlock()
+
+		for _, song := range session.Queue {
+			state.queue.Add(song)
+		}
+
+		if session.TextChannelID != "" {
+			s.ChannelMessageSend(session.TextChannelID, strings.TrimSpace("Back online, resuming playback. "+notice))
+		}
+
+		// playSound checks whether anyone is left in the channel we rejoined
+		// once the song starts.
+		if session.Current != nil {
+			go b.playSound(s, guildID, session.Current, session.Position)
+		} else {
+			go b.playNext(s, guildID, nil, false)
+		}
+	}
+
+	b.restoreAlwaysOn(s)
+}
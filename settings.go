@@ -0,0 +1,506 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// GuildSettings holds the preferences a guild can change at runtime. Pointer
+// fields overlay a global Config value and are nil when the guild uses the
+// global default.
+type GuildSettings struct {
+	Volume            int      `json:"volume"`                        // Playback volume in percent (0-200)
+	LoopMode          LoopMode `json:"loop_mode"`                     // Loop mode when the bot joins
+	Crossfade         *int     `json:"crossfade,omitempty"`           // Crossfade in seconds
+	InactivityTimeout *int     `json:"inactivity_timeout,omitempty"`  // Seconds before leaving an idle channel
+	MaxQueueLength    *int     `json:"max_queue_length,omitempty"`    // Queue limit, 0 for unlimited
+	VoteSkipPercent   *int     `json:"vote_skip_percent,omitempty"`   // Share of listeners needed to skip
+	DJRoleID          string   `json:"dj_role_id,omitempty"`          // Role allowed to control playback
+	AnnounceChannelID string   `json:"announce_channel_id,omitempty"` // Channel for now-playing messages
+	Preset            string   `json:"preset,omitempty"`              // /filter preset applied when the bot joins
+	FairQueue         bool     `json:"fair_queue,omitempty"`          // Play songs round-robin between requesters
+	AlwaysOn          bool     `json:"always_on,omitempty"`           // 24/7 mode: never leave the voice channel
+	AlwaysOnChannelID string   `json:"always_on_channel,omitempty"`   // Voice channel to stay in and rejoin
+	Autoplay          bool     `json:"autoplay,omitempty"`            // In 24/7 mode, play related songs when the queue ends
+	Radio             string   `json:"radio,omitempty"`               // In 24/7 mode, URL or search to play when the queue ends
+	EQ                EQGains  `json:"eq"`                            // Equalizer gain per band in dB
+}
+
+func defaultGuildSettings() GuildSettings {
+	return GuildSettings{
+		Volume: 100,
+	}
+}
+
+func (gs GuildSettings) crossfade(config *Config) time.Duration {
+	seconds := config.CrossfadeSeconds
+	if gs.Crossfade != nil {
+		seconds = *gs.Crossfade
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func (gs GuildSettings) inactivityTimeout(config *Config) time.Duration {
+	seconds := config.InactivityTimeout
+	if gs.InactivityTimeout != nil {
+		seconds = *gs.InactivityTimeout
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func (gs GuildSettings) maxQueueLength(config *Config) int {
+	if gs.MaxQueueLength != nil {
+		return *gs.MaxQueueLength
+	}
+	return config.MaxQueueLength
+}
+
+func (gs GuildSettings) voteSkipPercent(config *Config) int {
+	if gs.VoteSkipPercent != nil {
+		return *gs.VoteSkipPercent
+	}
+	return config.VoteSkipPercent
+}
+
+// SettingsStore keeps per-guild settings in memory and persists them to a
+// JSON file on every change.
+type SettingsStore struct {
+	path   string
+	guilds map[string]GuildSettings
+	mu     sync.Mutex
+}
+
+// LoadSettingsStore reads the settings file at path. A missing file is not an
+// error; it is created on the first change.
+func LoadSettingsStore(path string) (*SettingsStore, error) {
+	store := &SettingsStore{
+		path:   path,
+		guilds: make(map[string]GuildSettings),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading settings file: %w", err)
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parsing settings file: %w", err)
+	}
+
+	for guildID, entry := range raw {
+		// Start from the defaults so fields missing from older files keep
+		// sensible values.
+		settings := defaultGuildSettings()
+		if err := json.Unmarshal(entry, &settings); err != nil {
+			return nil, fmt.Errorf("parsing settings for guild %s: %w", guildID, err)
+		}
+		if reset := settings.resetInvalid(); len(reset) > 0 {
+			log.Printf("Settings file has invalid %s for guild %s, using the defaults", strings.Join(reset, ", "), guildID)
+		}
+		store.guilds[guildID] = settings
+	}
+
+	return store, nil
+}
+
+// resetInvalid puts the values /settings and the other commands would refuse,
+// such as from a hand-edited settings file, back to their defaults. It
+// returns the names of the settings it reset.
+func (gs *GuildSettings) resetInvalid() []string {
+	defaults := defaultGuildSettings()
+	var reset []string
+
+	if gs.Volume < 0 || gs.Volume > maxVolume {
+		gs.Volume = defaults.Volume
+		reset = append(reset, "volume")
+	}
+	if gs.LoopMode < LoopOff || gs.LoopMode > LoopQueue {
+		gs.LoopMode = defaults.LoopMode
+		reset = append(reset, "loop")
+	}
+	if gs.Crossfade != nil && (*gs.Crossfade < 0 || *gs.Crossfade > maxCrossfadeSeconds) {
+		gs.Crossfade = nil
+		reset = append(reset, "crossfade")
+	}
+	if gs.InactivityTimeout != nil && (*gs.InactivityTimeout < minInactivityTimeout || *gs.InactivityTimeout > maxInactivityTimeout) {
+		gs.InactivityTimeout = nil
+		reset = append(reset, "inactivity_timeout")
+	}
+	if gs.MaxQueueLength != nil && *gs.MaxQueueLength < 0 {
+		gs.MaxQueueLength = nil
+		reset = append(reset, "max_queue_length")
+	}
+	if gs.VoteSkipPercent != nil && (*gs.VoteSkipPercent < 0 || *gs.VoteSkipPercent > maxVoteSkipPercent) {
+		gs.VoteSkipPercent = nil
+		reset = append(reset, "vote_skip_percent")
+	}
+	if gs.Preset != "" && findAudioPreset(gs.Preset) == nil {
+		gs.Preset = ""
+		reset = append(reset, "preset")
+	}
+	for _, gain := range gs.EQ {
+		if ValidateEQGain(gain) != nil {
+			gs.EQ = EQGains{}
+			reset = append(reset, "eq")
+			break
+		}
+	}
+
+	return reset
+}
+
+// Get returns the settings for a guild, falling back to the defaults.
+func (s *SettingsStore) Get(guildID string) GuildSettings {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if settings, ok := s.guilds[guildID]; ok {
+		return settings
+	}
+	return defaultGuildSettings()
+}
+
+// AlwaysOnChannels returns the voice channel of every guild with 24/7 mode
+// on, keyed by guild ID.
+func (s *SettingsStore) AlwaysOnChannels() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	channels := make(map[string]string)
+	for guildID, settings := range s.guilds {
+		if settings.AlwaysOn && settings.AlwaysOnChannelID != "" {
+			channels[guildID] = settings.AlwaysOnChannelID
+		}
+	}
+	return channels
+}
+
+// Update applies fn to the settings of a guild and saves the store.
+func (s *SettingsStore) Update(guildID string, fn func(*GuildSettings)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	settings, ok := s.guilds[guildID]
+	if !ok {
+		settings = defaultGuildSettings()
+	}
+	fn(&settings)
+	s.guilds[guildID] = settings
+
+	return s.save()
+}
+
+// save writes the store to disk. It must be called with the mutex held.
+func (s *SettingsStore) save() error {
+	data, err := json.MarshalIndent(s.guilds, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding settings: %w", err)
+	}
+	return writeFileAtomic(s.path, data)
+}
+
+// writeFileAtomic writes to a temporary file first and renames it over path,
+// so a crash never leaves a truncated file behind.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("replacing %s: %w", path, err)
+	}
+	return nil
+}
+
+// canManageGuild reports whether the member invoking an interaction has the
+// Manage Server permission.
+func canManageGuild(i *discordgo.InteractionCreate) bool {
+	return i.Member != nil && i.Member.Permissions&discordgo.PermissionManageServer != 0
+}
+
+func (b *Bot) handleSettings(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if !canManageGuild(i) {
+		respondEphemeral(s, i, "You need the Manage Server permission to change settings")
+		return
+	}
+
+	subcommand := i.ApplicationCommandData().Options[0]
+	switch subcommand.Name {
+	case "view":
+		b.handleSettingsView(s, i)
+	case "set":
+		b.handleSettingsSet(s, i, subcommand.Options)
+	case "reset":
+		b.handleSettingsReset(s, i, subcommand.Options[0].StringValue())
+	}
+}
+
+func (b *Bot) handleSettingsView(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	config := b.guildConfig(i.GuildID)
+	settings := b.settings.Get(i.GuildID)
+
+	overridden := func(set bool) string {
+		if set {
+			return ""
+		}
+		return " (default)"
+	}
+
+	djRole := "None"
+	if settings.DJRoleID != "" {
+		djRole = "<@&" + settings.DJRoleID + ">"
+	}
+	announce := "Channel where the song was requested"
+	if settings.AnnounceChannelID != "" {
+		announce = "<#" + settings.AnnounceChannelID + ">"
+	}
+	preset := "None"
+	if settings.Preset != "" {
+		preset = settings.Preset
+	}
+	maxQueue := "Unlimited"
+	if n := settings.maxQueueLength(config); n > 0 {
+		maxQueue = fmt.Sprintf("%d songs", n)
+	}
+	fairQueue := "Off"
+	if settings.FairQueue {
+		fairQueue = "On"
+	}
+	voteSkip := "Off"
+	if percent := settings.voteSkipPercent(config); percent > 0 {
+		voteSkip = fmt.Sprintf("%d%% of listeners", percent)
+	}
+	alwaysOn := "Off"
+	if settings.AlwaysOn {
+		alwaysOn = "<#" + settings.AlwaysOnChannelID + ">" + describeFallback(settings.Autoplay, settings.Radio)
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title: "Server settings",
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Volume", Value: fmt.Sprintf("%d%%", settings.Volume), Inline: true},
+			{Name: "Loop mode", Value: settings.LoopMode.String(), Inline: true},
+			{Name: "Audio preset", Value: preset, Inline: true},
+			{Name: "Crossfade", Value: formatDuration(settings.crossfade(config)) + overridden(settings.Crossfade != nil), Inline: true},
+			{Name: "Inactivity timeout", Value: formatDuration(settings.inactivityTimeout(config)) + overridden(settings.InactivityTimeout != nil), Inline: true},
+			{Name: "Max queue length", Value: maxQueue + overridden(settings.MaxQueueLength != nil), Inline: true},
+			{Name: "Vote skip", Value: voteSkip + overridden(settings.VoteSkipPercent != nil), Inline: true},
+			{Name: "Fair queue", Value: fairQueue, Inline: true},
+			{Name: "DJ role", Value: djRole, Inline: true},
+			{Name: "Announce channel", Value: announce, Inline: true},
+			{Name: "24/7 mode", Value: alwaysOn},
+		},
+	}
+
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Embeds: []*discordgo.MessageEmbed{embed},
+			Flags:  discordgo.MessageFlagsEphemeral,
+		},
+	})
+}
+
+func (b *Bot) handleSettingsSet(s *discordgo.Session, i *discordgo.InteractionCreate, options []*discordgo.ApplicationCommandInteractionDataOption) {
+	if len(options) == 0 {
+		respondEphemeral(s, i, "Pick at least one setting to change")
+		return
+	}
+
+	// Validate everything before changing anything.
+	var changes []func(*GuildSettings)
+	var applied []string
+	for _, option := range options {
+		switch option.Name {
+		case "volume":
+			volume := int(option.IntValue())
+			if volume < 0 || volume > maxVolume {
+				respondEphemeral(s, i, fmt.Sprintf("Volume must be between 0 and %d", maxVolume))
+				return
+			}
+			changes = append(changes, func(gs *GuildSettings) { gs.Volume = volume })
+			applied = append(applied, fmt.Sprintf("volume: %d%%", volume))
+
+		case "loop":
+			mode, err := ParseLoopMode(option.StringValue())
+			if err != nil {
+				respondEphemeral(s, i, fmt.Sprintf("Error: %v", err))
+				return
+			}
+			changes = append(changes, func(gs *GuildSettings) { gs.LoopMode = mode })
+			applied = append(applied, fmt.Sprintf("loop mode: %s", mode))
+
+		case "inactivity_timeout":
+			seconds := int(option.IntValue())
+			if seconds < minInactivityTimeout || seconds > maxInactivityTimeout {
+				respondEphemeral(s, i, fmt.Sprintf("Inactivity timeout must be between %d and %d seconds", minInactivityTimeout, maxInactivityTimeout))
+				return
+			}
+			changes = append(changes, func(gs *GuildSettings) { gs.InactivityTimeout = &seconds })
+			applied = append(applied, fmt.Sprintf("inactivity timeout: %ds", seconds))
+
+		case "max_queue_length":
+			limit := int(option.IntValue())
+			if limit < 0 {
+				respondEphemeral(s, i, "Max queue length cannot be negative")
+				return
+			}
+			changes = append(changes, func(gs *GuildSettings) { gs.MaxQueueLength = &limit })
+			applied = append(applied, fmt.Sprintf("max queue length: %d", limit))
+
+		case "vote_skip_percent":
+			percent := int(option.IntValue())
+			if percent < 0 || percent > maxVoteSkipPercent {
+				respondEphemeral(s, i, fmt.Sprintf("Vote skip percent must be between 0 and %d", maxVoteSkipPercent))
+				return
+			}
+			changes = append(changes, func(gs *GuildSettings) { gs.VoteSkipPercent = &percent })
+			applied = append(applied, fmt.Sprintf("vote skip: %d%%", percent))
+
+		case "fair_queue":
+			fair := option.BoolValue()
+			changes = append(changes, func(gs *GuildSettings) { gs.FairQueue = fair })
+			applied = append(applied, fmt.Sprintf("fair queue: %t", fair))
+
+		case "dj_role":
+			roleID := option.RoleValue(s, i.GuildID).ID
+			changes = append(changes, func(gs *GuildSettings) { gs.DJRoleID = roleID })
+			applied = append(applied, fmt.Sprintf("DJ role: <@&%s>", roleID))
+
+		case "announce_channel":
+			channelID := option.ChannelValue(s).ID
+			changes = append(changes, func(gs *GuildSettings) { gs.AnnounceChannelID = channelID })
+			applied = append(applied, fmt.Sprintf("announce channel: <#%s>", channelID))
+
+		case "preset":
+			name := option.StringValue()
+			if name == "clear" {
+				name = ""
+			} else if findAudioPreset(name) == nil {
+				respondEphemeral(s, i, fmt.Sprintf("Unknown filter %q", name))
+				return
+			}
+			changes = append(changes, func(gs *GuildSettings) { gs.Preset = name })
+			applied = append(applied, fmt.Sprintf("audio preset: %s", option.StringValue()))
+		}
+	}
+
+	before := b.settings.Get(i.GuildID)
+	if err := b.settings.Update(i.GuildID, func(gs *GuildSettings) {
+		for _, change := range changes {
+			change(gs)
+		}
+	}); err != nil {
+		log.Printf("Error saving guild settings: %v", err)
+		respondEphemeral(s, i, "Error saving settings")
+		return
+	}
+
+	b.applySettings(i.GuildID, before)
+	respondEphemeral(s, i, "Updated "+strings.Join(applied, ", "))
+}
+
+func (b *Bot) handleSettingsReset(s *discordgo.Session, i *discordgo.InteractionCreate, name string) {
+	defaults := defaultGuildSettings()
+	before := b.settings.Get(i.GuildID)
+	if err := b.settings.Update(i.GuildID, func(gs *GuildSettings) {
+		switch name {
+		case "volume":
+			gs.Volume = defaults.Volume
+		case "loop":
+			gs.LoopMode = defaults.LoopMode
+		case "inactivity_timeout":
+			gs.InactivityTimeout = nil
+		case "max_queue_length":
+			gs.MaxQueueLength = nil
+		case "vote_skip_percent":
+			gs.VoteSkipPercent = nil
+		case "fair_queue":
+			gs.FairQueue = defaults.FairQueue
+		case "dj_role":
+			gs.DJRoleID = ""
+		case "announce_channel":
+			gs.AnnounceChannelID = ""
+		case "preset":
+			gs.Preset = ""
+		case "crossfade":
+			gs.Crossfade = nil
+		case "eq":
+			gs.EQ = EQGains{}
+		case "all":
+			// 24/7 mode has its own command and is left as it is.
+			defaults.AlwaysOn = gs.AlwaysOn
+			defaults.AlwaysOnChannelID = gs.AlwaysOnChannelID
+			defaults.Autoplay = gs.Autoplay
+			defaults.Radio = gs.Radio
+			*gs = defaults
+		}
+	}); err != nil {
+		log.Printf("Error saving guild settings: %v", err)
+		respondEphemeral(s, i, "Error saving settings")
+		return
+	}
+
+	b.applySettings(i.GuildID, before)
+	respondEphemeral(s, i, fmt.Sprintf("Reset %s to the default", strings.ReplaceAll(name, "_", " ")))
+}
+
+// applySettings pushes the settings that changed since before onto the live
+// state of a guild the bot is currently playing in.
+func (b *Bot) applySettings(guildID string, before GuildSettings) {
+	b.mu.RLock()
+	state, ok := b.guilds[guildID]
+	b.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	after := b.settings.Get(guildID)
+
+	state.mu.Lock()
+	if after.Volume != before.Volume {
+		state.volume = after.Volume
+	}
+	if after.LoopMode != before.LoopMode {
+		state.loopMode = after.LoopMode
+	}
+	config := b.guildConfig(guildID)
+	if crossfade := after.crossfade(config); crossfade != before.crossfade(config) {
+		state.crossfade = crossfade
+	}
+	state.mu.Unlock()
+	if after.FairQueue != before.FairQueue {
+		state.queue.SetFair(after.FairQueue)
+	}
+	state.refreshPrefetch()
+
+	if after.Preset != before.Preset || after.EQ != before.EQ {
+		state.setEffects(func() {
+			state.preset = findAudioPreset(after.Preset)
+			state.eq = after.EQ
+		})
+	}
+}
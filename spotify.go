@@ -2,24 +2,24 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/url"
 	"os/exec"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/zmb3/spotify"
 	"golang.org/x/oauth2/clientcredentials"
 )
 
-var (
-	spotifyClient *spotify.Client
-)
-
-func initSpotify() {
-	config := LoadConfig()
+// spotifyClient is replaced when a reload changes the credentials.
+var spotifyClient atomic.Pointer[spotify.Client]
 
+func initSpotify(config *Config) {
 	if config.SpotifyClientID != "" && config.SpotifyClientSecret != "" {
 		authConfig := &clientcredentials.Config{
 			ClientID:     config.SpotifyClientID,
@@ -33,78 +33,209 @@ func initSpotify() {
 		}
 
 		client := spotify.NewAuthenticator("").NewClient(accessToken)
-		spotifyClient = &client
+		spotifyClient.Store(&client)
 	}
 }
 
-func getSpotifyTrack(url string) (*Song, error) {
-	if spotifyClient == nil {
-		return nil, fmt.Errorf("spotify client not initialized")
-	}
+// spotifyShowEpisodes is how many of a podcast's latest episodes a show link
+// queues.
+const spotifyShowEpisodes = 5
 
-	parts := strings.Split(url, "track/")
-	if len(parts) < 2 {
-		return nil, fmt.Errorf("invalid spotify track URL")
-	}
-	trackID := spotify.ID(strings.Split(parts[1], "?")[0])
+// isSpotifyLink reports whether query is a Spotify URL or a spotify: URI.
+func isSpotifyLink(query string) bool {
+	return strings.Contains(query, "spotify.com") || strings.HasPrefix(query, "spotify:")
+}
 
-	track, err := spotifyClient.GetTrack(trackID)
-	if err != nil {
-		return nil, fmt.Errorf("getting spotify track: %w", err)
+// parseSpotifyLink splits a Spotify URL or URI into the kind of item it points
+// to, such as "track" or "album", and the item's ID. Both
+// https://open.spotify.com/album/<id> and spotify:album:<id> are accepted,
+// along with localized and legacy user playlist links.
+func parseSpotifyLink(link string) (string, spotify.ID, error) {
+	var segments []string
+	if rest, ok := strings.CutPrefix(link, "spotify:"); ok {
+		segments = strings.Split(rest, ":")
+	} else {
+		u, err := url.Parse(link)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid Spotify link: %w", err)
+		}
+		segments = strings.Split(strings.Trim(u.Path, "/"), "/")
 	}
 
-	return &Song{
-		Title:    fmt.Sprintf("%s - %s", track.Artists[0].Name, track.Name),
-		Duration: time.Duration(track.Duration) * time.Millisecond,
-	}, nil
+	// The kind and ID are always last, after any intl-xx, embed or
+	// user/<name> prefix.
+	if len(segments) < 2 || segments[len(segments)-1] == "" {
+		return "", "", fmt.Errorf("invalid Spotify link")
+	}
+	return segments[len(segments)-2], spotify.ID(segments[len(segments)-1]), nil
 }
 
-func getSpotifyPlaylist(url, channelID string) ([]*Song, error) {
-	if spotifyClient == nil {
+// resolveSpotifyURL looks up the track, album, playlist, artist or show a
+// Spotify link points to and finds each song on YouTube. Artist top tracks
+// and shows are looked up in the market country.
+func resolveSpotifyURL(link, channelID, market string) ([]*Song, error) {
+	client := spotifyClient.Load()
+	if client == nil {
 		return nil, fmt.Errorf("spotify client not initialized")
 	}
+	market = strings.ToUpper(market)
 
-	parts := strings.Split(url, "playlist/")
-	if len(parts) < 2 {
-		return nil, fmt.Errorf("invalid spotify playlist URL")
-	}
-	playlistID := spotify.ID(strings.Split(parts[1], "?")[0])
-
-	playlist, err := spotifyClient.GetPlaylistTracks(playlistID)
+	kind, id, err := parseSpotifyLink(link)
 	if err != nil {
-		return nil, fmt.Errorf("getting spotify playlist: %w", err)
+		return nil, err
 	}
 
 	var songs []*Song
-	var wg sync.WaitGroup
-	var mu sync.Mutex
+	var queries []string
+	switch kind {
+	case "track":
+		track, err := client.GetTrack(id)
+		if err != nil {
+			return nil, fmt.Errorf("getting spotify track: %w", err)
+		}
+		songs, queries = appendSpotifyTrack(songs, queries, track.SimpleTrack, track.Album, channelID)
 
-	for _, item := range playlist.Tracks {
-		if item.Track.ID == "" {
-			continue
+	case "album":
+		album, err := client.GetAlbum(id)
+		if err != nil {
+			return nil, fmt.Errorf("getting spotify album: %w", err)
 		}
-		wg.Add(1)
-		go func(track spotify.FullTrack) {
-			defer wg.Done()
-			ytURL, err := searchYoutube(fmt.Sprintf("%s - %s", track.Artists[0].Name, track.Name))
+		// Long albums come in pages.
+		page := &album.Tracks
+		for {
+			for _, track := range page.Tracks {
+				songs, queries = appendSpotifyTrack(songs, queries, track, album.SimpleAlbum, channelID)
+			}
+			err := client.NextPage(page)
+			if errors.Is(err, spotify.ErrNoMorePages) {
+				break
+			}
 			if err != nil {
-				log.Printf("could not find youtube video for %s: %v", track.Name, err)
-				return
+				return nil, fmt.Errorf("getting spotify album tracks: %w", err)
 			}
+		}
+
+	case "playlist":
+		playlist, err := client.GetPlaylistTracks(id)
+		if err != nil {
+			return nil, fmt.Errorf("getting spotify playlist: %w", err)
+		}
+		for _, item := range playlist.Tracks {
+			// Local files and removed tracks have no ID.
+			if item.Track.ID == "" {
+				continue
+			}
+			songs, queries = appendSpotifyTrack(songs, queries, item.Track.SimpleTrack, item.Track.Album, channelID)
+		}
+
+	case "artist":
+		tracks, err := client.GetArtistsTopTracks(id, market)
+		if err != nil {
+			return nil, fmt.Errorf("getting spotify artist: %w", err)
+		}
+		for _, track := range tracks {
+			songs, queries = appendSpotifyTrack(songs, queries, track.SimpleTrack, track.Album, channelID)
+		}
 
-			mu.Lock()
+	case "show":
+		show, err := client.GetShowOpt(&spotify.Options{Country: &market}, string(id))
+		if err != nil {
+			return nil, fmt.Errorf("getting spotify show: %w", err)
+		}
+		// Episodes are listed newest first.
+		episodes := show.Episodes.Episodes
+		if len(episodes) > spotifyShowEpisodes {
+			episodes = episodes[:spotifyShowEpisodes]
+		}
+		for _, episode := range episodes {
+			var cover string
+			if len(episode.Images) > 0 {
+				cover = episode.Images[0].URL
+			}
 			songs = append(songs, &Song{
-				URL:       ytURL,
-				Title:     track.Name,
-				Duration:  time.Duration(track.Duration) * time.Millisecond,
+				Title:     episode.Name,
+				Duration:  time.Duration(episode.Duration_ms) * time.Millisecond,
+				Uploader:  show.Name,
+				Thumbnail: cover,
 				ChannelID: channelID,
 			})
-			mu.Unlock()
-		}(item.Track)
+			queries = append(queries, fmt.Sprintf("%s %s", show.Name, episode.Name))
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported Spotify link: %s", kind)
+	}
+
+	if len(songs) == 0 {
+		return nil, fmt.Errorf("nothing playable found on Spotify")
 	}
+	return matchYoutube(songs, queries), nil
+}
+
+// appendSpotifyTrack adds a Spotify track to songs, along with the search
+// used to find it on YouTube.
+func appendSpotifyTrack(songs []*Song, queries []string, track spotify.SimpleTrack, album spotify.SimpleAlbum, channelID string) ([]*Song, []string) {
+	song := &Song{
+		Title:     track.Name,
+		Duration:  time.Duration(track.Duration) * time.Millisecond,
+		Uploader:  spotifyArtists(track.Artists),
+		Thumbnail: spotifyCover(album),
+		ChannelID: channelID,
+	}
+	query := track.Name
+	if len(track.Artists) > 0 {
+		query = fmt.Sprintf("%s - %s", track.Artists[0].Name, track.Name)
+	}
+	return append(songs, song), append(queries, query)
+}
+
+// matchYoutube searches YouTube for every song in parallel, using the query
+// at the same index, and fills in its URL. Songs without a match are dropped;
+// the rest keep their order.
+func matchYoutube(songs []*Song, queries []string) []*Song {
+	var wg sync.WaitGroup
+	found := make([]bool, len(songs))
 
+	for i, song := range songs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ytURL, err := searchYoutube(queries[i])
+			if err != nil {
+				log.Printf("could not find youtube video for %s: %v", song.Title, err)
+				return
+			}
+			song.URL = ytURL
+			found[i] = true
+		}()
+	}
 	wg.Wait()
-	return songs, nil
+
+	matched := songs[:0]
+	for i, song := range songs {
+		if found[i] {
+			matched = append(matched, song)
+		}
+	}
+	return matched
+}
+
+// spotifyArtists joins the names of a track's artists.
+func spotifyArtists(artists []spotify.SimpleArtist) string {
+	names := make([]string, len(artists))
+	for i, artist := range artists {
+		names[i] = artist.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// spotifyCover returns the URL of an album's largest cover image, if any.
+// Spotify lists images widest first.
+func spotifyCover(album spotify.SimpleAlbum) string {
+	if len(album.Images) == 0 {
+		return ""
+	}
+	return album.Images[0].URL
 }
 
 func searchYoutube(query string) (string, error) {
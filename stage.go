@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// stageTopicLimit is the longest topic Discord accepts for a stage.
+const stageTopicLimit = 120
+
+// errCannotSpeak is returned when the bot joins a stage it cannot speak on.
+var errCannotSpeak = errors.New("the bot cannot speak on this stage; give it the Request to Speak permission, or Mute Members to become a speaker by itself")
+
+// stageRequestNotice tells people why they cannot hear anything yet.
+const stageRequestNotice = "The bot has asked to speak on the stage. Invite it as a speaker to hear the music."
+
+// isStageChannel reports whether channelID is a stage channel.
+func isStageChannel(s *discordgo.Session, channelID string) bool {
+	channel, err := s.State.Channel(channelID)
+	if err != nil {
+		if channel, err = s.Channel(channelID); err != nil {
+			log.Printf("Error looking up voice channel %s: %v", channelID, err)
+			return false
+		}
+	}
+	return channel.Type == discordgo.ChannelTypeGuildStageVoice
+}
+
+// joinStage takes the bot onto channelID if it is a stage. It reports whether
+// it is one, along with any notice from becomeSpeaker.
+func joinStage(s *discordgo.Session, guildID, channelID string) (bool, string, error) {
+	if !isStageChannel(s, channelID) {
+		return false, "", nil
+	}
+	notice, err := becomeSpeaker(s, guildID, channelID)
+	return true, notice, err
+}
+
+// becomeSpeaker moves the bot from the audience onto the stage it joined. With
+// Mute Members it becomes a speaker right away; otherwise it raises its hand
+// and returns a notice saying a moderator has to invite it up. It returns
+// errCannotSpeak when it may do neither.
+func becomeSpeaker(s *discordgo.Session, guildID, channelID string) (string, error) {
+	err := updateOwnVoiceState(s, guildID, map[string]any{
+		"channel_id": channelID,
+		"suppress":   false,
+	})
+	if err == nil {
+		return "", nil
+	}
+	if !isPermissionError(err) {
+		return "", fmt.Errorf("becoming a stage speaker: %w", err)
+	}
+
+	err = updateOwnVoiceState(s, guildID, map[string]any{
+		"channel_id":                 channelID,
+		"request_to_speak_timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+	if err == nil {
+		return stageRequestNotice, nil
+	}
+	if isPermissionError(err) {
+		return "", errCannotSpeak
+	}
+	return "", fmt.Errorf("requesting to speak: %w", err)
+}
+
+// updateOwnVoiceState changes the bot's voice state in a guild, which is how
+// stage speakers are managed.
+func updateOwnVoiceState(s *discordgo.Session, guildID string, data map[string]any) error {
+	endpoint := discordgo.EndpointGuild(guildID) + "/voice-states/@me"
+	_, err := s.RequestWithBucketID(http.MethodPatch, endpoint, data, endpoint)
+	return err
+}
+
+// isPermissionError reports whether err is Discord refusing a request for
+// lack of permissions.
+func isPermissionError(err error) bool {
+	var restErr *discordgo.RESTError
+	if !errors.As(err, &restErr) {
+		return false
+	}
+	if restErr.Response != nil && restErr.Response.StatusCode == http.StatusForbidden {
+		return true
+	}
+	return restErr.Message != nil && restErr.Message.Code == discordgo.ErrCodeMissingPermissions
+}
+
+// setStageTopic shows the title of song as the topic of the stage, starting
+// the stage if it is not live yet. Doing so needs the stage moderator
+// permissions; without them the topic is left alone.
+func setStageTopic(s *discordgo.Session, channelID string, song *Song) {
+	topic := song.Title
+	if runes := []rune(topic); len(runes) > stageTopicLimit {
+		topic = string(runes[:stageTopicLimit-1]) + "…"
+	}
+	if topic == "" {
+		return
+	}
+
+	_, err := s.StageInstanceEdit(channelID, &discordgo.StageInstanceParams{Topic: topic})
+	var restErr *discordgo.RESTError
+	if errors.As(err, &restErr) && restErr.Message != nil && restErr.Message.Code == discordgo.ErrCodeUnknownStageInstance {
+		_, err = s.StageInstanceCreate(&discordgo.StageInstanceParams{
+			ChannelID: channelID,
+			Topic:     topic,
+		})
+	}
+	if err != nil {
+		log.Printf("Error setting stage topic: %v", err)
+	}
+}
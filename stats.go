@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// statsTopRequesters is how many members /stats ranks.
+const statsTopRequesters = 5
+
+// UserStats counts what one member has requested in a guild.
+type UserStats struct {
+	Name       string        `json:"name"`        // Display name when they last requested a song
+	Requested  int           `json:"requested"`   // Songs added to the queue
+	Played     int           `json:"played"`      // Songs of theirs that started playing
+	QueuedTime time.Duration `json:"queued_time"` // Total length of the songs they added
+}
+
+// StatsStore keeps per-member request statistics for every guild and
+// persists them to a JSON file on every change. An empty path keeps them in
+// memory only.
+type StatsStore struct {
+	path   string
+	guilds map[string]map[string]*UserStats
+	mu     sync.Mutex
+}
+
+// LoadStatsStore reads the stats file at path. A missing file is not an
+// error; it is created on the first change.
+func LoadStatsStore(path string) (*StatsStore, error) {
+	store := &StatsStore{
+		path:   path,
+		guilds: make(map[string]map[string]*UserStats),
+	}
+	if path == "" {
+		return store, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading stats file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &store.guilds); err != nil {
+		return nil, fmt.Errorf("parsing stats file: %w", err)
+	}
+	return store, nil
+}
+
+// RecordRequests counts songs as requested by the members who queued them.
+func (s *StatsStore) RecordRequests(guildID string, songs []*Song) {
+	s.update(guildID, songs, func(stats *UserStats, song *Song) {
+		stats.Name = song.RequesterName
+		stats.Requested++
+		stats.QueuedTime += song.Duration
+	})
+}
+
+// RecordPlay counts song as played for the member who queued it.
+func (s *StatsStore) RecordPlay(guildID string, song *Song) {
+	s.update(guildID, []*Song{song}, func(stats *UserStats, song *Song) {
+		stats.Played++
+	})
+}
+
+func (s *StatsStore) update(guildID string, songs []*Song, fn func(*UserStats, *Song)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users, ok := s.guilds[guildID]
+	if !ok {
+		users = make(map[string]*UserStats)
+		s.guilds[guildID] = users
+	}
+
+	changed := false
+	for _, song := range songs {
+		if song.RequesterID == "" {
+			continue
+		}
+		stats, ok := users[song.RequesterID]
+		if !ok {
+			stats = &UserStats{}
+			users[song.RequesterID] = stats
+		}
+		fn(stats, song)
+		changed = true
+	}
+
+	if changed {
+		if err := s.save(); err != nil {
+			log.Printf("Error saving stats: %v", err)
+		}
+	}
+}
+
+// Get returns the stats of a member in a guild.
+func (s *StatsStore) Get(guildID, userID string) UserStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stats, ok := s.guilds[guildID][userID]; ok {
+		return *stats
+	}
+	return UserStats{}
+}
+
+// Top returns the stats of the n members who requested the most songs in a
+// guild, most first.
+func (s *StatsStore) Top(guildID string, n int) []UserStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := s.guilds[guildID]
+	ids := make([]string, 0, len(users))
+	for id := range users {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(a, b int) bool {
+		if users[ids[a]].Requested != users[ids[b]].Requested {
+			return users[ids[a]].Requested > users[ids[b]].Requested
+		}
+		return ids[a] < ids[b]
+	})
+
+	ids = ids[:min(n, len(ids))]
+	stats := make([]UserStats, len(ids))
+	for idx, id := range ids {
+		stats[idx] = *users[id]
+	}
+	return stats
+}
+
+// save writes the store to disk. It must be called with the mutex held.
+func (s *StatsStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.guilds, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding stats: %w", err)
+	}
+	return writeFileAtomic(s.path, data)
+}
+
+func (b *Bot) handleStats(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	// Stats are kept per server, so there are none to show in a DM.
+	if i.Member == nil {
+		respondEphemeral(s, i, "This can only be used in a server")
+		return
+	}
+
+	user := i.Member.User
+	name := i.Member.DisplayName()
+	if options := i.ApplicationCommandData().Options; len(options) > 0 {
+		user = options[0].UserValue(s)
+		name = user.DisplayName()
+		if member, ok := i.ApplicationCommandData().Resolved.Members[user.ID]; ok && member.Nick != "" {
+			name = member.Nick
+		}
+	}
+
+	stats := b.stats.Get(i.GuildID, user.ID)
+
+	var top strings.Builder
+	for idx, ranked := range b.stats.Top(i.GuildID, statsTopRequesters) {
+		fmt.Fprintf(&top, "%d. %s • %d songs\n", idx+1, ranked.Name, ranked.Requested)
+	}
+	if top.Len() == 0 {
+		top.WriteString("Nobody has requested anything yet")
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title: "Stats for " + name,
+		Thumbnail: &discordgo.MessageEmbedThumbnail{
+			URL: user.AvatarURL("128"),
+		},
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Songs requested", Value: fmt.Sprintf("%d", stats.Requested), Inline: true},
+			{Name: "Songs played", Value: fmt.Sprintf("%d", stats.Played), Inline: true},
+			{Name: "Time queued", Value: formatDuration(stats.QueuedTime), Inline: true},
+			{Name: "Top requesters", Value: top.String()},
+		},
+	}
+
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Embeds: []*discordgo.MessageEmbed{embed},
+			Flags:  discordgo.MessageFlagsEphemeral,
+		},
+	})
+}
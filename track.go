@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	pcmChannels   = 2
+	pcmFrameRate  = 48000
+	pcmFrameSize  = 960 // Samples per channel in one 20ms frame
+	frameDuration = pcmFrameSize * time.Second / pcmFrameRate
+
+	// trackBufferFrames is how many decoded frames a track holds ahead of
+	// playback (5 seconds).
+	trackBufferFrames = 250
+
+	// prefetchLead is how long before the end of a song the next one starts
+	// resolving and buffering.
+	prefetchLead = 30 * time.Second
+)
+
+// track is a running ffmpeg pipeline decoding one song into PCM frames. A
+// reader goroutine buffers frames ahead of playback, so a track can be started
+// before it is needed and picked up without waiting on ffmpeg.
+type track struct {
+	song      *Song
+	streamURL string
+	effects   audioEffects
+	cmd       *exec.Cmd
+	frames    chan []int16
+
+	// position is the offset of the next frame within the song. It is only
+	// touched by the goroutine reading frames.
+	position time.Duration
+
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// startTrack starts decoding streamURL from offset with the given effects.
+func startTrack(song *Song, streamURL string, offset time.Duration, fx audioEffects, config *Config) (*track, error) {
+	cmd, out, err := startFFmpeg(streamURL, offset, config.BuildAudioFilter(fx.Filters...), config)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &track{
+		song:      song,
+		streamURL: streamURL,
+		effects:   fx,
+		cmd:       cmd,
+		frames:    make(chan []int16, trackBufferFrames),
+		position:  offset,
+		stopped:   make(chan struct{}),
+	}
+	go t.fill(out)
+	return t, nil
+}
+
+// fill reads PCM from ffmpeg into the frame buffer until the stream ends or
+// the track is stopped.
+func (t *track) fill(out io.ReadCloser) {
+	defer close(t.frames)
+	defer out.Close()
+
+	for {
+		pcm := make([]int16, pcmFrameSize*pcmChannels)
+		err := binary.Read(out, binary.LittleEndian, &pcm)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return
+		}
+		if err != nil {
+			select {
+			case <-t.stopped:
+			default:
+				log.Printf("Error reading from ffmpeg stdout: %v", err)
+			}
+			return
+		}
+
+		select {
+		case t.frames <- pcm:
+		case <-t.stopped:
+			return
+		}
+	}
+}
+
+// readFrame returns the next frame, blocking until ffmpeg has decoded it. It
+// returns nil once the track has ended.
+func (t *track) readFrame() []int16 {
+	pcm, ok := <-t.frames
+	if !ok {
+		return nil
+	}
+	// Effects like nightcore play the source faster or slower, so one
+	// frame covers more or less of the song.
+	t.position += time.Duration(float64(frameDuration) * t.effects.Speed)
+	return pcm
+}
+
+// stop kills ffmpeg if it is still running and waits for it to exit. It is
+// safe to call more than once.
+func (t *track) stop() {
+	t.stopOnce.Do(func() {
+		close(t.stopped)
+		t.cmd.Process.Kill()
+		if err := t.cmd.Wait(); err != nil && err.Error() != "signal: killed" {
+			log.Printf("ffmpeg error: %v", err)
+		}
+	})
+}
+
+// startFFmpeg starts decoding streamURL from offset into 48kHz stereo PCM
+// through audioFilter and returns the running command along with its stdout.
+func startFFmpeg(streamURL string, offset time.Duration, audioFilter string, config *Config) (*exec.Cmd, io.ReadCloser, error) {
+	ffmpegArgs := []string{
+		"-reconnect", "1",
+		"-reconnect_streamed", "1",
+		"-reconnect_delay_max", fmt.Sprintf("%d", config.FFmpegReconnectDelay),
+		"-nostdin",
+	}
+
+	if offset > 0 {
+		ffmpegArgs = append(ffmpegArgs, "-ss", fmt.Sprintf("%.3f", offset.Seconds()))
+	}
+
+	ffmpegArgs = append(ffmpegArgs,
+		"-i", streamURL,
+		"-f", "s16le",
+		"-ar", "48000",
+		"-ac", "2",
+	)
+
+	if audioFilter != "" {
+		ffmpegArgs = append(ffmpegArgs, "-af", audioFilter)
+	}
+
+	ffmpegArgs = append(ffmpegArgs, "pipe:1")
+
+	ffmpeg := exec.Command("ffmpeg", ffmpegArgs...)
+
+	// Set process priority for real-time audio
+	ffmpeg.SysProcAttr = &syscall.SysProcAttr{
+		// On Linux, set nice value for higher priority
+		// Nice: -10, // Requires root
+	}
+
+	ffmpegErr, err := ffmpeg.StderrPipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting ffmpeg stderr pipe: %w", err)
+	}
+
+	ffmpegOut, err := ffmpeg.StdoutPipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting ffmpeg stdout pipe: %w", err)
+	}
+
+	// Enhanced error logging with filter
+	go func() {
+		scanner := bufio.NewScanner(ffmpegErr)
+		for scanner.Scan() {
+			line := scanner.Text()
+			// Filter out common non-error messages
+			if !strings.Contains(line, "Press [q] to stop") &&
+				!strings.Contains(line, "size=") &&
+				!strings.Contains(line, "time=") {
+				log.Printf("[ffmpeg] %s", line)
+			}
+		}
+	}()
+
+	if err := ffmpeg.Start(); err != nil {
+		return nil, nil, err
+	}
+	log.Printf("ffmpeg started with optimized settings at %s", formatDuration(offset))
+
+	return ffmpeg, ffmpegOut, nil
+}
+
+// prefetch is the upcoming song being resolved and buffered while the current
+// one finishes playing.
+type prefetch struct {
+	song      *Song
+	effects   audioEffects
+	track     *track        // nil until resolved, or if resolving failed
+	ready     chan struct{} // closed once resolving has finished
+	cancelled bool
+	mu        sync.Mutex
+}
+
+// resolve looks up the stream URL for the prefetched song and starts
+// buffering it.
+func (p *prefetch) resolve(config *Config) {
+	defer close(p.ready)
+
+	streamURL, err := getStreamURL(p.song.URL, config)
+	if err != nil {
+		log.Printf("Error prefetching stream URL for %s: %v", p.song.Title, err)
+		return
+	}
+
+	t, err := startTrack(p.song, streamURL, 0, p.effects, config)
+	if err != nil {
+		log.Printf("Error prefetching %s: %v", p.song.Title, err)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cancelled {
+		t.stop()
+		return
+	}
+	p.track = t
+	log.Printf("Prefetched %s", p.song.Title)
+}
+
+// take waits for resolving to finish and returns the buffered track, if any.
+func (p *prefetch) take() *track {
+	<-p.ready
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t := p.track
+	p.track = nil
+	return t
+}
+
+// cancel stops the buffered track, or makes an in-flight resolve discard it.
+func (p *prefetch) cancel() {
+	p.mu.Lock()
+	p.cancelled = true
+	t := p.track
+	p.track = nil
+	p.mu.Unlock()
+
+	if t != nil {
+		t.stop()
+	}
+}
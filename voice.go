@@ -0,0 +1,266 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// voiceStallTimeout is how long a frame may wait to be sent before the
+	// voice connection is considered dead.
+	voiceStallTimeout = 5 * time.Second
+	// voiceRecoverWait is how long discordgo gets to restore a stalled
+	// connection by itself, as it does when the voice server changes.
+	voiceRecoverWait = 10 * time.Second
+	// voiceRejoinAttempts is how many times to rejoin the channel after
+	// that before giving up.
+	voiceRejoinAttempts = 3
+)
+
+// voiceStateUpdate watches people joining and leaving the bot's voice
+// channel, and the bot itself being moved or disconnected.
+func (b *Bot) voiceStateUpdate(s *discordgo.Session, v *discordgo.VoiceStateUpdate) {
+	if v.UserID == s.State.User.ID {
+		b.botVoiceStateUpdate(s, v)
+		return
+	}
+
+	b.mu.RLock()
+	state, ok := b.guilds[v.GuildID]
+	b.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	state.mu.Lock()
+	var channelID string
+	if state.voice != nil {
+		channelID = state.voice.ChannelID
+	}
+	state.mu.Unlock()
+
+	joined := v.ChannelID == channelID
+	left := v.BeforeUpdate != nil && v.BeforeUpdate.ChannelID == channelID
+	if channelID == "" || (!joined && !left) {
+		return
+	}
+
+	b.checkListeners(s, v.GuildID, state)
+}
+
+// checkListeners pauses playback and starts the grace period before leaving
+// when nobody is left in the bot's voice channel, and resumes when someone
+// comes back. Songs paused by hand stay paused. In 24/7 mode the bot pauses
+// but never leaves.
+func (b *Bot) checkListeners(s *discordgo.Session, guildID string, state *GuildState) {
+	state.mu.Lock()
+	if state.voice == nil {
+		state.mu.Unlock()
+		return
+	}
+	channelID := state.voice.ChannelID
+	state.mu.Unlock()
+
+	empty := len(voiceListeners(s, guildID, channelID)) == 0
+	grace := time.Duration(b.guildConfig(guildID).EmptyChannelTimeout) * time.Second
+	alwaysOn := b.settings.Get(guildID).AlwaysOn
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	defer b.nowPlaying.markDirty(guildID)
+
+	if state.voice == nil {
+		return
+	}
+
+	if empty {
+		if !state.paused && state.current != nil {
+			state.paused = true
+			state.autoPaused = true
+			state.voice.Speaking(false)
+		}
+		if alwaysOn {
+			// Wait for listeners however long it takes.
+			if state.emptyTimer != nil {
+				state.emptyTimer.Stop()
+				state.emptyTimer = nil
+			}
+			return
+		}
+		if state.emptyTimer != nil {
+			return
+		}
+		log.Printf("Voice channel in guild %s is empty, leaving in %v", guildID, grace)
+		state.emptyTimer = time.AfterFunc(grace, func() {
+			b.leaveEmptyChannel(s, guildID, state)
+		})
+		return
+	}
+
+	if state.emptyTimer != nil {
+		state.emptyTimer.Stop()
+		state.emptyTimer = nil
+	}
+	if state.autoPaused {
+		state.paused = false
+		state.autoPaused = false
+		state.voice.Speaking(true)
+	}
+}
+
+// leaveEmptyChannel disconnects once the grace period after everyone left
+// has passed, unless someone has come back in the meantime.
+func (b *Bot) leaveEmptyChannel(s *discordgo.Session, guildID string, state *GuildState) {
+	state.mu.Lock()
+	if state.emptyTimer == nil || state.voice == nil {
+		// Someone came back and cancelled the timer.
+		state.mu.Unlock()
+		return
+	}
+	state.emptyTimer = nil
+	channelID := state.voice.ChannelID
+	state.mu.Unlock()
+
+	if len(voiceListeners(s, guildID, channelID)) > 0 {
+		return
+	}
+
+	log.Printf("Leaving empty voice channel in guild %s", guildID)
+	state.stopPlayback(s)
+	b.disconnectFromGuild(guildID)
+}
+
+// botVoiceStateUpdate follows the bot when someone drags it to another
+// channel, and stops playback when someone disconnects it.
+func (b *Bot) botVoiceStateUpdate(s *discordgo.Session, v *discordgo.VoiceStateUpdate) {
+	b.mu.RLock()
+	state, ok := b.guilds[v.GuildID]
+	b.mu.RUnlock()
+	if !ok {
+		// The bot left on its own.
+		return
+	}
+
+	state.mu.Lock()
+	vc := state.voice
+	state.mu.Unlock()
+	if vc == nil {
+		return
+	}
+
+	if v.ChannelID == "" {
+		log.Printf("Disconnected from voice in guild %s", v.GuildID)
+		state.stopPlayback(s)
+		b.disconnectFromGuild(v.GuildID)
+		return
+	}
+
+	vc.Lock()
+	moved := vc.ChannelID != v.ChannelID
+	// discordgo records the new channel too, but concurrently with this
+	// handler.
+	vc.ChannelID = v.ChannelID
+	vc.Unlock()
+	if !moved {
+		return
+	}
+
+	log.Printf("Moved to voice channel %s in guild %s", v.ChannelID, v.GuildID)
+	b.followStage(s, v.GuildID, v.ChannelID, state)
+	b.checkListeners(s, v.GuildID, state)
+}
+
+// followStage records whether the bot's channel is now a stage and steps
+// onto it if so. Problems are only logged, as nobody is waiting on a reply.
+func (b *Bot) followStage(s *discordgo.Session, guildID, channelID string, state *GuildState) {
+	stage, notice, err := joinStage(s, guildID, channelID)
+	state.mu.Lock()
+	state.stage = stage
+	state.mu.Unlock()
+
+	if err != nil {
+		log.Printf("Error joining stage in guild %s: %v", guildID, err)
+	} else if notice != "" {
+		log.Printf("Requested to speak on the stage in guild %s", guildID)
+	}
+}
+
+// reconnectVoice restores the voice connection after audio stopped going
+// out, first by waiting for discordgo to reconnect and then by rejoining the
+// channel. It reports whether the guild has a working connection again.
+func (b *Bot) reconnectVoice(s *discordgo.Session, guildID string, state *GuildState) bool {
+	state.mu.Lock()
+	vc := state.voice
+	state.mu.Unlock()
+	if vc == nil {
+		return false
+	}
+
+	log.Printf("Voice connection in guild %s stalled, waiting for it to recover", guildID)
+	for deadline := time.Now().Add(voiceRecoverWait); time.Now().Before(deadline); {
+		state.mu.Lock()
+		left := state.voice != vc
+		state.mu.Unlock()
+		if left {
+			// Disconnected on purpose while waiting.
+			return false
+		}
+		if voiceReady(s, guildID, vc) {
+			return true
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+
+	vc.RLock()
+	channelID := vc.ChannelID
+	vc.RUnlock()
+
+	for attempt := 1; attempt <= voiceRejoinAttempts; attempt++ {
+		if !b.isCurrentState(guildID, state) {
+			// Stopped while reconnecting.
+			return false
+		}
+
+		log.Printf("Rejoining voice channel %s in guild %s (attempt %d)", channelID, guildID, attempt)
+		joined, err := s.ChannelVoiceJoin(guildID, channelID, false, true)
+		if err != nil {
+			log.Printf("Error rejoining voice channel: %v", err)
+			time.Sleep(time.Duration(attempt) * 2 * time.Second)
+			continue
+		}
+
+		if !b.isCurrentState(guildID, state) {
+			joined.Disconnect()
+			return false
+		}
+		state.mu.Lock()
+		state.voice = joined
+		state.mu.Unlock()
+		// Rejoining a stage puts the bot back in the audience.
+		b.followStage(s, guildID, channelID, state)
+		return true
+	}
+	return false
+}
+
+// voiceReady reports whether vc is still the guild's voice connection and
+// is sending audio.
+func voiceReady(s *discordgo.Session, guildID string, vc *discordgo.VoiceConnection) bool {
+	s.RLock()
+	current := s.VoiceConnections[guildID]
+	s.RUnlock()
+
+	vc.RLock()
+	defer vc.RUnlock()
+	return current == vc && vc.Ready
+}
+
+// isCurrentState reports whether state still belongs to a guild the bot is
+// connected to.
+func (b *Bot) isCurrentState(guildID string, state *GuildState) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.guilds[guildID] == state
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// voiceListeners returns the users other than bots in a voice channel.
+func voiceListeners(s *discordgo.Session, guildID, channelID string) []string {
+	guild, err := s.State.Guild(guildID)
+	if err != nil {
+		return nil
+	}
+
+	var listeners []string
+	for _, vs := range guild.VoiceStates {
+		if vs.ChannelID != channelID || vs.UserID == s.State.User.ID {
+			continue
+		}
+		if member, err := s.State.Member(guildID, vs.UserID); err == nil && member.User != nil && member.User.Bot {
+			continue
+		}
+		listeners = append(listeners, vs.UserID)
+	}
+	return listeners
+}
+
+// votesNeeded returns how many of listeners must vote to reach percent,
+// rounding up and never less than one.
+func votesNeeded(listeners, percent int) int {
+	return max(1, (listeners*percent+99)/100)
+}
+
+// voteSkip handles a skip request. The requester of the current song and
+// DJs skip straight away, as does anyone when vote
+// skipping is turned off; everyone else adds a vote, and the song is skipped
+// once enough of the listeners in the bot's channel have voted. It reports
+// whether the song was skipped along with a reply for the user.
+func (b *Bot) voteSkip(s *discordgo.Session, i *discordgo.InteractionCreate, state *GuildState) (bool, string) {
+	settings := b.settings.Get(i.GuildID)
+	percent := settings.voteSkipPercent(b.guildConfig(i.GuildID))
+	userID := i.Member.User.ID
+
+	state.mu.Lock()
+	if state.current == nil || state.voice == nil {
+		state.mu.Unlock()
+		return false, "Nothing to skip"
+	}
+	requester := state.current.RequesterID == userID
+	channelID := state.voice.ChannelID
+	state.mu.Unlock()
+
+	if percent == 0 || requester || isDJ(i, settings) {
+		if !state.skip() {
+			return false, "Nothing to skip"
+		}
+		return true, "Skipped the current song"
+	}
+
+	listeners := voiceListeners(s, i.GuildID, channelID)
+	if !slices.Contains(listeners, userID) {
+		return false, "You must be in the voice channel to vote to skip"
+	}
+
+	state.mu.Lock()
+	if state.current == nil {
+		state.mu.Unlock()
+		return false, "Nothing to skip"
+	}
+	if state.skipVotes == nil {
+		state.skipVotes = make(map[string]bool)
+	}
+	alreadyVoted := state.skipVotes[userID]
+	state.skipVotes[userID] = true
+
+	// Only count votes from people who are still listening.
+	for voter := range state.skipVotes {
+		if !slices.Contains(listeners, voter) {
+			delete(state.skipVotes, voter)
+		}
+	}
+	votes := len(state.skipVotes)
+	needed := votesNeeded(len(listeners), percent)
+	state.votesNeeded = needed
+	state.mu.Unlock()
+
+	if votes >= needed {
+		if !state.skip() {
+			return false, "Nothing to skip"
+		}
+		return true, fmt.Sprintf("Vote passed (%d/%d), skipped the current song", votes, needed)
+	}
+
+	if alreadyVoted {
+		return false, fmt.Sprintf("You already voted to skip (%d/%d)", votes, needed)
+	}
+	return false, fmt.Sprintf("Voted to skip (%d/%d)", votes, needed)
+}